@@ -3,11 +3,55 @@ import { BASE_URL } from "../utils/AppConst";
 
 export const axiosAuth = () => {
   const token = localStorage.getItem("token") as string;
-  return axios.create({
+  const instance = axios.create({
     baseURL: BASE_URL,
     headers: {
       "Content-Type": "application/json",
       Authorization: `Bearer ${token}`,
     },
   });
+
+  // access tokens are short lived, retry once with a refreshed token
+  instance.interceptors.response.use(undefined, async (error) => {
+    const request = error.config;
+    // unverified accounts can't checkout or sell yet, send them to verify
+    if (
+      error.response?.status === 403 &&
+      error.response?.data?.code === "ACCOUNT_NOT_VERIFIED"
+    ) {
+      window.location.assign("/verify");
+      return Promise.reject(error);
+    }
+    if (error.response?.status !== 401 || request._retried) {
+      return Promise.reject(error);
+    }
+    const token = await RefreshTokenApi();
+    if (!token) {
+      return Promise.reject(error);
+    }
+    request._retried = true;
+    request.headers.Authorization = `Bearer ${token}`;
+    return instance(request);
+  });
+
+  return instance;
+};
+
+export const RefreshTokenApi = async (): Promise<string | undefined> => {
+  const refreshToken = localStorage.getItem("refresh_token");
+  if (!refreshToken) {
+    return undefined;
+  }
+  try {
+    const response = await axios.post(`${BASE_URL}/auth/refresh`, {
+      refresh_token: refreshToken,
+    });
+    const { token, refresh_token } = response.data;
+    localStorage.setItem("token", token);
+    localStorage.setItem("refresh_token", refresh_token);
+    return token;
+  } catch (error) {
+    localStorage.removeItem("refresh_token");
+    return undefined;
+  }
 };
@@ -43,6 +43,20 @@ export const FetchCategories = async () => {
   }
 };
 
+export const FetchSuggestions = async (query: string) => {
+  try {
+    const response = await axios.get(`${PRODUCT_URL}/products/suggest`, {
+      params: { q: query },
+    });
+    return response.data;
+  } catch (error) {
+    console.log(error);
+    return {
+      message: "error occured",
+    };
+  }
+};
+
 export const FetchSellerProducts = async () => {
   const auth = axiosAuth();
   try {
@@ -38,9 +38,14 @@ export const RegisterApi = async ({
   }
 };
 
-export const GetVerificationCode = async (token: string) => {
+export const GetVerificationCode = async (
+  token: string,
+  channel?: "sms" | "email"
+) => {
   const auth = axiosAuth();
-  const response = await auth.get(`${BASE_URL}/users/verify`);
+  const response = await auth.get(`${BASE_URL}/users/verify`, {
+    params: channel ? { channel } : undefined,
+  });
   return response.data;
 };
 
@@ -31,9 +31,10 @@ const LoginPage: React.FC<LoginProps> = ({}) => {
       });
       return;
     }
-    const { token, message } = await LoginAPI(email, password);
+    const { token, refresh_token, message } = await LoginAPI(email, password);
     if (token) {
       localStorage.setItem("token", token);
+      localStorage.setItem("refresh_token", refresh_token);
       dispatch(userLogin({ token } as UserModel));
       await FetchProfile();
       navigate("/");
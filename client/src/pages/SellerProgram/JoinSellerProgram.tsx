@@ -34,7 +34,7 @@ const JoinSellerProgram: React.FC<SellerProgramProps> = ({}) => {
   const [country, setCountry] = useState("");
 
   const onTapJoinProgram = async () => {
-    const { token, message } = await JoinSellerProgramAPI({
+    const { token, refresh_token, message } = await JoinSellerProgramAPI({
       first_name,
       last_name,
       phone_number,
@@ -46,6 +46,7 @@ const JoinSellerProgram: React.FC<SellerProgramProps> = ({}) => {
       // TODO: This should be status code
       if (token) {
         localStorage.setItem("token", token);
+        localStorage.setItem("refresh_token", refresh_token);
       }
       toast("Successfully joined seller program!", {
         type: "success",
@@ -2,14 +2,14 @@
 import * as React from "react";
 import { useDispatch } from "react-redux";
 import { useNavigate } from "react-router-dom";
-import { OrderModel } from "../../types/models/order-model";
+import { SellerOrderModel } from "../../types/models/order-model";
 import { Container } from "@mui/material";
 import { AppCSS, Lbl, Spacer, TapButton, TxtInput } from "../../components";
 import { ColDiv, RowDiv } from "../../components/Misc/misc.styled";
 import moment from "moment";
 
 interface OrderTableProps {
-  orders: OrderModel[];
+  orders: SellerOrderModel[];
 }
 
 export const OrderTable: React.FC<OrderTableProps> = ({ orders }) => {
@@ -70,12 +70,12 @@ export const OrderTable: React.FC<OrderTableProps> = ({ orders }) => {
             {filterLeads()
               .slice(page * rowsPerPage, page * rowsPerPage + rowsPerPage)
               .map((row, index) => {
-                const key = `key-${row.id}`;
+                const key = `key-${row.order_item_id}`;
                 return (
                   <tr key={key} style={{ width: "100%", height: "40px" }}>
                     <td>
                       <p>
-                        {moment(row.CreatedAt).format(
+                        {moment(row.created_at).format(
                           "MMMM Do YYYY, h:mm:ss a"
                         )}
                       </p>
@@ -97,7 +97,7 @@ export const OrderTable: React.FC<OrderTableProps> = ({ orders }) => {
                     <td>
                       <TapButton
                         title="View"
-                        onTap={() => navigate(`/seller-order/${row.id}`)}
+                        onTap={() => navigate(`/seller-order/${row.order_item_id}`)}
                         bgColor={AppCSS.WHITE}
                         borderColor={AppCSS.RED}
                         color={AppCSS.RED}
@@ -310,9 +310,9 @@ export const SellerOrderDetails: React.FC<DashboardProps> = ({}) => {
                   />
                   <TxtInput
                     disable={true}
-                    value={`${
-                      sellerOrderInfo.order_status === 0 ? "PENDING" : "PAID"
-                    }`}
+                    value={`${(
+                      sellerOrderInfo.order_status || "pending"
+                    ).toUpperCase()}`}
                     placeholder="Damaged Date"
                     onChange={() => {}}
                   />
@@ -45,7 +45,7 @@ import {
 } from "../../state/reducers/productSlice";
 import { EditProductPopup } from "./EditProductPopup";
 import { OrderTable } from "./OrdersTable";
-import { OrderModel } from "../../types/models/order-model";
+import { SellerOrderModel } from "../../types/models/order-model";
 
 interface ProductViewProps {}
 
@@ -92,7 +92,7 @@ const ProductView: React.FC<ProductViewProps> = ({}) => {
   const onFetchOrders = async () => {
     const { data, message } = await FetchSellerOrders();
     if (data) {
-      dispatch(setOrders(data as OrderModel[]));
+      dispatch(setOrders(data as SellerOrderModel[]));
     } else {
       console.log(`Error: ${message}`);
     }
@@ -30,17 +30,22 @@ const VerifyPage: React.FC<LoginProps> = ({}) => {
     getVerificationCode();
   }, []);
 
-  const getVerificationCode = async () => {
+  const getVerificationCode = async (channel?: "sms" | "email") => {
     const token = localStorage.getItem("token");
     if (token !== null) {
-      const { data } = await GetVerificationCode(token);
-      if (data) {
-        toast("Enter the code sent your phone number!", {
+      const { message } = await GetVerificationCode(token, channel);
+      if (message) {
+        toast(
+          channel === "email"
+            ? "Enter the code sent to your email!"
+            : "Enter the verification code we sent you!",
+          {
           type: "success",
-          style: {
-            width: "400px",
-          },
-        });
+            style: {
+              width: "400px",
+            },
+          }
+        );
       }
     }
   };
@@ -64,7 +69,7 @@ const VerifyPage: React.FC<LoginProps> = ({}) => {
       const status = await VerifyCode(token as string, otp);
 
       if (status === 200) {
-        toast("Phone number verified successfully!", {
+        toast("Account verified successfully!", {
           type: "success",
           style: {
             width: "400px",
@@ -137,7 +142,7 @@ const VerifyPage: React.FC<LoginProps> = ({}) => {
               fontSize: 16,
             }}
           >
-            Verify your phone
+            Verify your account
           </p>
         </RowDiv>
         <Spacer size={2} direction="col" />
@@ -158,6 +163,17 @@ const VerifyPage: React.FC<LoginProps> = ({}) => {
             radius={30}
           />
         </RowDiv>
+        <Spacer size={1} direction="col" />
+        <RowDiv
+          style={{
+            justifyContent: "center",
+            fontSize: "14px",
+          }}
+        >
+          <a href="#" onClick={() => getVerificationCode("email")}>
+            Send the code by email instead
+          </a>
+        </RowDiv>
       </CenterBox>
     );
   };
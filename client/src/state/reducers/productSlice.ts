@@ -1,13 +1,13 @@
 import { PayloadAction, createSlice } from "@reduxjs/toolkit";
 import { ProductModel, CartModel, CategoryModel } from "../../types";
-import { OrderModel, SellerOrderModel } from "../../types/models/order-model";
+import { SellerOrderModel } from "../../types/models/order-model";
 
 export interface ProductState {
   products: ProductModel[];
   sellerProducts: ProductModel[];
   categories: CategoryModel[];
   currentProduct: ProductModel;
-  currentSellerOrders: OrderModel[];
+  currentSellerOrders: SellerOrderModel[];
   sellerOrderInfo: SellerOrderModel;
 }
 
@@ -16,7 +16,7 @@ const initialState: ProductState = {
   categories: {} as CategoryModel[],
   sellerProducts: {} as ProductModel[],
   currentProduct: {} as ProductModel,
-  currentSellerOrders: {} as OrderModel[],
+  currentSellerOrders: {} as SellerOrderModel[],
   sellerOrderInfo: {} as SellerOrderModel,
 };
 
@@ -36,7 +36,7 @@ const productSlice = createSlice({
     setProduct(state, action: PayloadAction<ProductModel>) {
       state.currentProduct = action.payload;
     },
-    setOrders(state, action: PayloadAction<OrderModel[]>) {
+    setOrders(state, action: PayloadAction<SellerOrderModel[]>) {
       state.currentSellerOrders = action.payload;
     },
     setSellerOrder(state, action: PayloadAction<SellerOrderModel>) {
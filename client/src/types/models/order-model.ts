@@ -12,8 +12,8 @@ export interface OrderModel {
 }
 
 export interface SellerOrderModel {
-  order_ref_number: number;
-  order_status: number;
+  order_ref_number: string;
+  order_status: string;
   created_at: string;
   order_item_id: number;
   product_id: number;
@@ -9,6 +9,8 @@ export interface CartModel {
   qty: number;
   created_at: string;
   updated_at: string;
+  issues?: ("price_changed" | "out_of_stock" | "unavailable")[];
+  current_price?: number;
 }
 
 export interface ProductModel {
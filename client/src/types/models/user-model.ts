@@ -8,6 +8,18 @@ export interface UserModel {
   phone: string;
   createdAt: string;
   user_type: string;
+  addresses?: AddressModel[];
+}
+
+export interface AddressModel {
+  id: number;
+  address_line1: string;
+  address_line2: string;
+  city: string;
+  post_code: number;
+  country: string;
+  is_default_shipping: boolean;
+  is_default_billing: boolean;
 }
 
 export interface RegisterModel {
@@ -7,25 +7,57 @@ import (
 	"github.com/joho/godotenv"
 )
 
+const (
+	PaymentProviderStripe = "stripe"
+	PaymentProviderFake   = "fake"
+)
+
 type TwilioConfig struct {
 	AccountSID        string
 	AuthToken         string
 	FromContactNumber string
 }
 
+// SmtpConfig enables the email notification channel when Host is set.
+type SmtpConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
 type StripeConfig struct {
 	StripeSecretKey string
 	SuccessUrl      string
 	CancelUrl       string
 	PublishableKey  string
+	WebhookSecret   string
+}
+
+// JwtConfig enables asymmetric token signing, see helper.KeySet. When
+// KeysDir is empty tokens are signed with APP_SECRET.
+type JwtConfig struct {
+	KeysDir      string
+	SigningKeyId string
 }
 
 type AppConfig struct {
-	ServerPort   string
-	Dsn          string
-	AppSecret    string
-	TwilioConfig TwilioConfig
-	StripeConfig StripeConfig
+	ServerPort             string
+	Dsn                    string
+	AppSecret              string
+	JwtConfig              JwtConfig
+	PasswordResetUrl       string
+	RequireSellerTwoFactor bool
+	TwilioConfig           TwilioConfig
+	SmtpConfig             SmtpConfig
+	NotificationStub       bool
+	NotificationStubFile   string
+	PaymentProvider        string
+	StripeConfig           StripeConfig
+
+	// FakeWebhookSecret signs the webhooks of the fake payment provider.
+	FakeWebhookSecret string
 }
 
 func SetUpEnv() (cfg AppConfig, err error) {
@@ -49,54 +81,142 @@ func SetUpEnv() (cfg AppConfig, err error) {
 		return AppConfig{}, errors.New("app secret env not found")
 	}
 
-	twilioAccountSID := os.Getenv("TWILIO_ACCOUNT_SID")
-	if len(twilioAccountSID) < 1 {
-		return AppConfig{}, errors.New("twilio Account SID env not found")
+	jwtConfig := JwtConfig{
+		KeysDir:      os.Getenv("JWT_KEYS_DIR"),
+		SigningKeyId: os.Getenv("JWT_SIGNING_KEY_ID"),
 	}
-
-	twilioAuthToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	if len(twilioAuthToken) < 1 {
-		return AppConfig{}, errors.New("twilio Auth Token env not found")
+	if len(jwtConfig.KeysDir) > 0 && len(jwtConfig.SigningKeyId) < 1 {
+		return AppConfig{}, errors.New("jwt signing key id env not found")
 	}
 
-	twilioFromPhoneNumber := os.Getenv("TWILIO_FROM_PHONE_NUMBER")
-	if len(twilioFromPhoneNumber) < 1 {
-		return AppConfig{}, errors.New("twilio From Phone Number env not found")
+	passwordResetUrl := os.Getenv("PASSWORD_RESET_URL")
+
+	requireSellerTwoFactor := os.Getenv("REQUIRE_SELLER_2FA") == "true"
+
+	twilioConfig, err := setUpTwilio()
+	if err != nil {
+		return AppConfig{}, err
 	}
 
-	stripeSecretKey := os.Getenv("STRIPE_SECRET_KEY")
-	if len(stripeSecretKey) < 1 {
-		return AppConfig{}, errors.New("stripe secret key env not found")
+	smtpConfig, err := setUpSmtp()
+	if err != nil {
+		return AppConfig{}, err
 	}
 
-	stripeSuccessUrl := os.Getenv("STRIPE_SUCCESS_URL")
-	if len(stripeSuccessUrl) < 1 {
-		return AppConfig{}, errors.New("stripe success url env not found")
+	notificationStub := os.Getenv("NOTIFICATION_STUB") == "true"
+	notificationStubFile := os.Getenv("NOTIFICATION_STUB_FILE")
+
+	if !notificationStub && twilioConfig.AccountSID == "" && smtpConfig.Host == "" {
+		return AppConfig{}, errors.New("no notification channel configured, set the twilio or smtp env or NOTIFICATION_STUB=true")
 	}
 
-	stripeCancelUrl := os.Getenv("STRIPE_CANCEL_URL")
-	if len(stripeCancelUrl) < 1 {
-		return AppConfig{}, errors.New("stripe cancel url env not found")
+	paymentProvider := os.Getenv("PAYMENT_PROVIDER")
+	if len(paymentProvider) < 1 {
+		paymentProvider = PaymentProviderStripe
+	}
+	if paymentProvider != PaymentProviderStripe && paymentProvider != PaymentProviderFake {
+		return AppConfig{}, errors.New("payment provider must be stripe or fake")
 	}
 
-	publishableKey := os.Getenv("STRIPE_PUB_KEY")
-	if len(publishableKey) < 1 {
-		return AppConfig{}, errors.New("stripe publishable key env not found")
+	stripeConfig, err := setUpStripe(paymentProvider == PaymentProviderStripe)
+	if err != nil {
+		return AppConfig{}, err
 	}
 
+	// optional, the fake provider's webhook is disabled when it is not set
+	fakeWebhookSecret := os.Getenv("FAKE_PAYMENT_WEBHOOK_SECRET")
+
+	return AppConfig{ServerPort: httpPort, Dsn: Dsn, AppSecret: appSecret, JwtConfig: jwtConfig, PasswordResetUrl: passwordResetUrl, RequireSellerTwoFactor: requireSellerTwoFactor, TwilioConfig: twilioConfig, SmtpConfig: smtpConfig, NotificationStub: notificationStub, NotificationStubFile: notificationStubFile, PaymentProvider: paymentProvider, StripeConfig: stripeConfig, FakeWebhookSecret: fakeWebhookSecret}, nil
+
+}
+
+// setUpTwilio reads the optional Twilio settings that enable SMS. They are
+// all required once any of them is set.
+func setUpTwilio() (TwilioConfig, error) {
+
 	twilioConfig := TwilioConfig{
-		AccountSID:        twilioAccountSID,
-		AuthToken:         twilioAuthToken,
-		FromContactNumber: twilioFromPhoneNumber,
+		AccountSID:        os.Getenv("TWILIO_ACCOUNT_SID"),
+		AuthToken:         os.Getenv("TWILIO_AUTH_TOKEN"),
+		FromContactNumber: os.Getenv("TWILIO_FROM_PHONE_NUMBER"),
+	}
+
+	if twilioConfig.AccountSID == "" && twilioConfig.AuthToken == "" && twilioConfig.FromContactNumber == "" {
+		return TwilioConfig{}, nil
+	}
+
+	if len(twilioConfig.AccountSID) < 1 {
+		return TwilioConfig{}, errors.New("twilio Account SID env not found")
+	}
+
+	if len(twilioConfig.AuthToken) < 1 {
+		return TwilioConfig{}, errors.New("twilio Auth Token env not found")
+	}
+
+	if len(twilioConfig.FromContactNumber) < 1 {
+		return TwilioConfig{}, errors.New("twilio From Phone Number env not found")
 	}
 
+	return twilioConfig, nil
+}
+
+// setUpSmtp reads the optional SMTP settings that enable email.
+func setUpSmtp() (SmtpConfig, error) {
+
+	smtpConfig := SmtpConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+
+	if smtpConfig.Host == "" {
+		return SmtpConfig{}, nil
+	}
+
+	if len(smtpConfig.Port) < 1 {
+		smtpConfig.Port = "587"
+	}
+
+	if len(smtpConfig.From) < 1 {
+		return SmtpConfig{}, errors.New("smtp from address env not found")
+	}
+
+	return smtpConfig, nil
+}
+
+// setUpStripe reads the Stripe settings, which are only mandatory when Stripe
+// is the selected payment provider.
+func setUpStripe(required bool) (StripeConfig, error) {
+
 	stripeConfig := StripeConfig{
-		StripeSecretKey: stripeSecretKey,
-		SuccessUrl:      stripeSuccessUrl,
-		CancelUrl:       stripeCancelUrl,
-		PublishableKey:  publishableKey,
+		StripeSecretKey: os.Getenv("STRIPE_SECRET_KEY"),
+		SuccessUrl:      os.Getenv("STRIPE_SUCCESS_URL"),
+		CancelUrl:       os.Getenv("STRIPE_CANCEL_URL"),
+		PublishableKey:  os.Getenv("STRIPE_PUB_KEY"),
+		// optional, the payment webhook is disabled when it is not set
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+	}
+
+	if !required {
+		return stripeConfig, nil
+	}
+
+	if len(stripeConfig.StripeSecretKey) < 1 {
+		return StripeConfig{}, errors.New("stripe secret key env not found")
 	}
 
-	return AppConfig{ServerPort: httpPort, Dsn: Dsn, AppSecret: appSecret, TwilioConfig: twilioConfig, StripeConfig: stripeConfig}, nil
+	if len(stripeConfig.SuccessUrl) < 1 {
+		return StripeConfig{}, errors.New("stripe success url env not found")
+	}
+
+	if len(stripeConfig.CancelUrl) < 1 {
+		return StripeConfig{}, errors.New("stripe cancel url env not found")
+	}
+
+	if len(stripeConfig.PublishableKey) < 1 {
+		return StripeConfig{}, errors.New("stripe publishable key env not found")
+	}
 
+	return stripeConfig, nil
 }
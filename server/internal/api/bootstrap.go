@@ -0,0 +1,35 @@
+package api
+
+import (
+	"ecommerce/config"
+	"ecommerce/internal/helper"
+	"ecommerce/internal/repository"
+	"ecommerce/internal/service"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// CreateAdmin creates the first admin account, or promotes an existing user,
+// so the admin routes can be reached on a fresh install.
+func CreateAdmin(config config.AppConfig, email string, password string) error {
+
+	db, err := gorm.Open(postgres.Open(config.Dsn), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+
+	err = migrate(db)
+	if err != nil {
+		return err
+	}
+
+	svc := service.UserService{
+		Repo:   repository.NewUserRepository(db),
+		SRepo:  repository.NewSessionRepository(db),
+		Auth:   helper.SetUpAuth(config.AppSecret, nil, nil),
+		Config: config,
+	}
+
+	return svc.CreateAdmin(email, password)
+}
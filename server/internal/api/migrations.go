@@ -0,0 +1,192 @@
+package api
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/internal/helper"
+	"ecommerce/pkg/money"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// migrate brings the schema up to date and backfills data of older
+// versions.
+func migrate(db *gorm.DB) error {
+
+	err := db.AutoMigrate(
+		&domain.User{},
+		&domain.BankAccount{},
+		&domain.Category{},
+		&domain.Product{},
+		&domain.Cart{},
+		&domain.Address{},
+		&domain.Order{},
+		&domain.OrderItem{},
+		&domain.OrderStatusHistory{},
+		&domain.Payment{},
+		&domain.Refund{},
+		&domain.StockReservation{},
+		&domain.CheckoutSnapshot{},
+		&domain.CheckoutSnapshotItem{},
+		&domain.Session{},
+		&domain.RefreshToken{},
+		&domain.PasswordResetToken{},
+		&domain.AuthThrottle{},
+		&domain.RecoveryCode{})
+	if err != nil {
+		return err
+	}
+
+	err = migrateMoneyColumns(db)
+	if err != nil {
+		return err
+	}
+
+	err = migrateProductSearch(db)
+	if err != nil {
+		return err
+	}
+
+	// orders created before the status lifecycle existed were all paid
+	err = db.Model(&domain.Order{}).Where("status = '' OR status IS NULL").Update("status", domain.OrderStatusPaid).Error
+	if err != nil {
+		return err
+	}
+
+	err = migrateAddressBook(db)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// legacyAddressSQL selects the oldest address of every user, which was their
+// only address before the address book existed.
+const legacyAddressSQL = `SELECT DISTINCT ON (user_id) * FROM addresses ORDER BY user_id, id`
+
+// addressBookBackfill makes the legacy address the default of users that have
+// no default yet, and copies it onto orders and checkouts from before
+// shipping addresses were snapshotted.
+var addressBookBackfill = []string{
+	`UPDATE addresses SET is_default_shipping = true WHERE id IN (SELECT id FROM (` + legacyAddressSQL + `) a
+		WHERE NOT EXISTS (SELECT 1 FROM addresses d WHERE d.user_id = a.user_id AND d.is_default_shipping))`,
+	`UPDATE addresses SET is_default_billing = true WHERE id IN (SELECT id FROM (` + legacyAddressSQL + `) a
+		WHERE NOT EXISTS (SELECT 1 FROM addresses d WHERE d.user_id = a.user_id AND d.is_default_billing))`,
+	`UPDATE orders SET shipping_address_line1 = a.address_line1, shipping_address_line2 = a.address_line2,
+		shipping_city = a.city, shipping_post_code = a.post_code, shipping_country = a.country
+		FROM (` + legacyAddressSQL + `) a
+		WHERE a.user_id = orders.user_id AND coalesce(orders.shipping_address_line1, '') = ''`,
+	`UPDATE checkout_snapshots SET shipping_address_line1 = a.address_line1, shipping_address_line2 = a.address_line2,
+		shipping_city = a.city, shipping_post_code = a.post_code, shipping_country = a.country
+		FROM (` + legacyAddressSQL + `) a
+		WHERE a.user_id = checkout_snapshots.user_id AND coalesce(checkout_snapshots.shipping_address_line1, '') = ''`,
+}
+
+func migrateAddressBook(db *gorm.DB) error {
+
+	for _, stmt := range addressBookBackfill {
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("migrating address book: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// migrateMoneyColumns moves amounts stored in the legacy float columns into
+// the minor unit and currency columns of money.Money, then drops the legacy
+// column. Tables that were already migrated are skipped.
+func migrateMoneyColumns(db *gorm.DB) error {
+
+	columns := []struct {
+		model  interface{}
+		legacy string
+		prefix string
+	}{
+		{&domain.Product{}, "price", "price_"},
+		{&domain.Cart{}, "price", "price_"},
+		{&domain.OrderItem{}, "price", "price_"},
+		{&domain.Order{}, "amount", "amount_"},
+		{&domain.Payment{}, "amount", "amount_"},
+		{&domain.Refund{}, "amount", "amount_"},
+	}
+
+	for _, c := range columns {
+
+		if !db.Migrator().HasColumn(c.model, c.legacy) {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+
+			err := tx.Model(c.model).
+				Where(c.legacy + " IS NOT NULL").
+				Updates(map[string]interface{}{
+					c.prefix + "minor":    gorm.Expr(fmt.Sprintf("ROUND(%s * 100)", c.legacy)),
+					c.prefix + "currency": money.DefaultCurrency,
+				}).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Migrator().DropColumn(c.model, c.legacy)
+		})
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", c.legacy, err)
+		}
+	}
+
+	return nil
+}
+
+// productSearchDDL sets up the full text and trigram indexes used by
+// repository.NewSearchRepository. The weighted tsvector is a generated
+// column, so it never goes stale.
+var productSearchDDL = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'B')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`,
+}
+
+func migrateProductSearch(db *gorm.DB) error {
+
+	for _, ddl := range productSearchDDL {
+		if err := db.Exec(ddl).Error; err != nil {
+			return fmt.Errorf("migrating product search: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// encryptTotpSecrets encrypts the TOTP secrets stored in plain text before
+// they were encrypted at rest.
+func encryptTotpSecrets(db *gorm.DB, auth helper.Auth) error {
+
+	var users []domain.User
+	err := db.Select("id", "totp_secret").Where("totp_secret <> '' AND totp_secret NOT LIKE 'enc:%'").Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+
+		encrypted, err := auth.EncryptSecret(u.TotpSecret)
+		if err != nil {
+			return err
+		}
+
+		err = db.Model(&domain.User{}).Where("id = ? AND totp_secret = ?", u.ID, u.TotpSecret).Update("totp_secret", encrypted).Error
+		if err != nil {
+			return fmt.Errorf("encrypting totp secret of user %d: %w", u.ID, err)
+		}
+	}
+
+	return nil
+}
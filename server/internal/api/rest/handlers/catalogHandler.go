@@ -7,6 +7,7 @@ import (
 	"ecommerce/internal/helper"
 	"ecommerce/internal/repository"
 	"ecommerce/internal/service"
+	"ecommerce/pkg/money"
 	"errors"
 	"log"
 	"net/http"
@@ -30,11 +31,16 @@ func SetUpCatalogRoutes(rh *rest.RestHandler) {
 	catalogSvc := service.CatalogService{
 		Auth:   rh.Auth,
 		Repo:   catalogRepo,
+		Uow:    repository.NewUnitOfWork(rh.DB),
+		Si:     rh.Si,
 		Config: rh.Config,
 	}
 	prodSvc := service.ProductService{
 		Auth:   rh.Auth,
 		Repo:   prodRepo,
+		CRepo:  catalogRepo,
+		SRepo:  repository.NewSearchRepository(rh.DB),
+		Si:     rh.Si,
 		Config: rh.Config,
 	}
 
@@ -44,17 +50,24 @@ func SetUpCatalogRoutes(rh *rest.RestHandler) {
 	}
 
 	app.Get("/products", handler.GetProducts)
+	app.Get("/products/search", handler.SearchProducts)
+	app.Get("/products/suggest", handler.SuggestProducts)
 	app.Get("/products/:id", handler.GetProduct)
 	app.Get("/categories", handler.GetCategories)
+	app.Get("/categories/tree", handler.GetCategoryTree)
 	app.Get("/categories/:id", handler.GetCategoryById)
+	app.Get("/categories/:id/breadcrumbs", handler.GetCategoryBreadcrumbs)
 
-	sellerRoutes := app.Group("/seller", rh.Auth.AuthorizeSeller)
-	// categories
-	sellerRoutes.Post("/categories", handler.CreateCategories)
-	sellerRoutes.Patch("/categories/:id", handler.EditCategories)
-	sellerRoutes.Delete("/categories/:id", handler.DeleteCategories)
+	// categories are shared by all sellers
+	adminRoutes := app.Group("/admin/categories", rh.Auth.Authorize(domain.PermissionManageCategories))
+	adminRoutes.Post("/", handler.CreateCategories)
+	adminRoutes.Patch("/:id", handler.EditCategories)
+	adminRoutes.Patch("/:id/move", handler.MoveCategory)
+	adminRoutes.Delete("/:id", handler.DeleteCategories)
+
+	sellerRoutes := app.Group("/seller", rh.Auth.Authorize(domain.PermissionSell))
 	// products
-	sellerRoutes.Post("/products", handler.CreateProducts)
+	sellerRoutes.Post("/products", rh.Auth.RequireVerified, handler.CreateProducts)
 	sellerRoutes.Get("/products", handler.GetSellerProducts)
 	sellerRoutes.Get("/products/:id", handler.GetProduct)
 	sellerRoutes.Patch("/products/:id", handler.UpdateStock)
@@ -74,6 +87,36 @@ func (h CatalogHandler) GetCategories(ctx *fiber.Ctx) error {
 
 }
 
+func (h CatalogHandler) GetCategoryTree(ctx *fiber.Ctx) error {
+
+	tree, err := h.catalogSvc.GetCategoryTree()
+	if err != nil {
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Category tree fetched successfully", tree)
+
+}
+
+func (h CatalogHandler) GetCategoryBreadcrumbs(ctx *fiber.Ctx) error {
+
+	catId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || catId < 0 {
+		return rest.BadRequest(ctx, "please provide a valid category id")
+	}
+
+	crumbs, err := h.catalogSvc.GetBreadcrumbs(uint(catId))
+	if err != nil {
+		if errors.Is(err, domain.ErrorCategoryNotFound) {
+			return rest.NotFoundError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Breadcrumbs fetched successfully", crumbs)
+
+}
+
 func (h CatalogHandler) GetCategoryById(ctx *fiber.Ctx) error {
 
 	catId, err := strconv.Atoi(ctx.Params("id"))
@@ -103,6 +146,9 @@ func (h CatalogHandler) CreateCategories(ctx *fiber.Ctx) error {
 
 	err := h.catalogSvc.CreateCategory(payload)
 	if err != nil {
+		if errors.Is(err, domain.ErrorParentCategoryNotFound) {
+			return rest.BadRequest(ctx, err.Error())
+		}
 		return rest.InternalError(ctx, err)
 	}
 
@@ -124,15 +170,47 @@ func (h CatalogHandler) EditCategories(ctx *fiber.Ctx) error {
 
 	cat, err := h.catalogSvc.EditCategory(uint(catId), payload)
 	if err != nil {
-		if errors.Is(err, domain.ErrorCategoryNotFound) {
-			return rest.NotFoundError(ctx, err)
-		}
-		return rest.InternalError(ctx, err)
+		return categoryError(ctx, err)
 	}
 
 	return rest.SuccessResponse(ctx, http.StatusOK, "Category edited successfully", cat)
 }
 
+func (h CatalogHandler) MoveCategory(ctx *fiber.Ctx) error {
+
+	payload := dto.MoveCategoryDTO{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide proper valid input")
+	}
+
+	catId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || catId < 0 {
+		return rest.BadRequest(ctx, "please provide a valid category id")
+	}
+
+	cat, err := h.catalogSvc.MoveCategory(uint(catId), payload)
+	if err != nil {
+		return categoryError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Category moved successfully", cat)
+}
+
+// categoryError maps the errors of category changes to responses.
+func categoryError(ctx *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, domain.ErrorCategoryNotFound):
+		return rest.NotFoundError(ctx, err)
+	case errors.Is(err, domain.ErrorParentCategoryNotFound),
+		errors.Is(err, domain.ErrorCategoryCycle),
+		errors.Is(err, domain.ErrorInvalidDeleteMode):
+		return rest.BadRequest(ctx, err.Error())
+	case errors.Is(err, domain.ErrorCategoryNotEmpty):
+		return rest.ConflictError(ctx, err)
+	}
+	return rest.InternalError(ctx, err)
+}
+
 func (h CatalogHandler) DeleteCategories(ctx *fiber.Ctx) error {
 
 	catId, err := strconv.Atoi(ctx.Params("id"))
@@ -140,12 +218,12 @@ func (h CatalogHandler) DeleteCategories(ctx *fiber.Ctx) error {
 		return rest.BadRequest(ctx, "please provide a valid category id")
 	}
 
-	err = h.catalogSvc.DeleteCategory(uint(catId))
+	// reject unless the admin chooses to cascade or reparent
+	mode := domain.CategoryDeleteMode(ctx.Query("mode", string(domain.CategoryDeleteReject)))
+
+	err = h.catalogSvc.DeleteCategory(uint(catId), mode)
 	if err != nil {
-		if errors.Is(err, domain.ErrorCategoryNotFound) {
-			return rest.NotFoundError(ctx, err)
-		}
-		return rest.InternalError(ctx, err)
+		return categoryError(ctx, err)
 	}
 
 	return rest.SuccessResponse(ctx, http.StatusOK, "Category deleted successfully", nil)
@@ -162,20 +240,60 @@ func (h CatalogHandler) CreateProducts(ctx *fiber.Ctx) error {
 
 	prod, err := h.prodSvc.CreateProduct(payload, user)
 	if err != nil {
+		if errors.Is(err, domain.ErrorInvalidPrice) {
+			return rest.BadRequest(ctx, err.Error())
+		}
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusCreated, "Product created successfully", prod)
+	return rest.SuccessResponse(ctx, http.StatusCreated, "Product created successfully", dto.NewProductResponse(*prod))
 }
 
 func (h CatalogHandler) GetProducts(ctx *fiber.Ctx) error {
 
-	prods, err := h.prodSvc.GetProducts()
+	filter := dto.ProductFilter{}
+	if err := ctx.QueryParser(&filter); err != nil {
+		return rest.BadRequest(ctx, "please provide valid query parameters")
+	}
+
+	if !filter.IsValidSort() {
+		return rest.BadRequest(ctx, "sort must be newest, price_asc, price_desc or name")
+	}
+
+	prods, pagination, err := h.prodSvc.GetProducts(filter)
+	if err != nil {
+		if errors.Is(err, money.ErrorInvalidAmount) {
+			return rest.BadRequest(ctx, "please provide a valid price range")
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.PaginatedResponse(ctx, http.StatusOK, "Products fetched successfully", dto.NewProductResponses(prods), pagination)
+}
+
+func (h CatalogHandler) SearchProducts(ctx *fiber.Ctx) error {
+
+	query := dto.ProductSearchQuery{}
+	if err := ctx.QueryParser(&query); err != nil {
+		return rest.BadRequest(ctx, "please provide valid query parameters")
+	}
+
+	hits, pagination, err := h.prodSvc.SearchProducts(query)
 	if err != nil {
+		if errors.Is(err, domain.ErrorEmptySearchQuery) {
+			return rest.BadRequest(ctx, err.Error())
+		}
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Products fetched successfully", prods)
+	return rest.PaginatedResponse(ctx, http.StatusOK, "Products fetched successfully", dto.NewProductSearchResponses(hits), pagination)
+}
+
+func (h CatalogHandler) SuggestProducts(ctx *fiber.Ctx) error {
+
+	suggestions := h.prodSvc.SuggestProducts(ctx.Query("q"), ctx.QueryInt("limit"))
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Suggestions fetched successfully", dto.NewSuggestionResponses(suggestions))
 }
 
 func (h CatalogHandler) GetProduct(ctx *fiber.Ctx) error {
@@ -193,7 +311,7 @@ func (h CatalogHandler) GetProduct(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Product fetched successfully", prod)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Product fetched successfully", dto.NewProductResponse(*prod))
 }
 
 func (h CatalogHandler) UpdateStock(ctx *fiber.Ctx) error {
@@ -220,7 +338,7 @@ func (h CatalogHandler) UpdateStock(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Product Stock updated successfully", prod)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Product Stock updated successfully", dto.NewProductResponse(*prod))
 }
 
 func (h CatalogHandler) DeleteProducts(ctx *fiber.Ctx) error {
@@ -269,7 +387,7 @@ func (h CatalogHandler) EditProducts(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Product edited successfully", prod)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Product edited successfully", dto.NewProductResponse(*prod))
 }
 
 func (h CatalogHandler) GetSellerProducts(ctx *fiber.Ctx) error {
@@ -281,6 +399,6 @@ func (h CatalogHandler) GetSellerProducts(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Products fetched successfully", prods)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Products fetched successfully", dto.NewProductResponses(prods))
 
 }
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/internal/service"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (r *stubProductRepo) CreateProduct(p *domain.Product) (*domain.Product, error) {
+	p.ID = uint(len(r.created) + 1)
+	r.created = append(r.created, *p)
+	return p, nil
+}
+
+func TestCreateProductsRejectsPrice(t *testing.T) {
+
+	pRepo := &stubProductRepo{}
+	catalog := CatalogHandler{prodSvc: service.ProductService{Repo: pRepo}}
+
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("user", domain.User{ID: 2, UserType: domain.SELLER})
+		return ctx.Next()
+	})
+	app.Post("/seller/products", catalog.CreateProducts)
+
+	for _, price := range []string{"-5", "0", "-0.01"} {
+		res, err := app.Test(jsonRequest(http.MethodPost, "/seller/products", `{"name":"Mug","category_id":1,"price":`+price+`,"stock":3}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("price %s: status = %d, want %d", price, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+	if len(pRepo.created) != 0 {
+		t.Errorf("created %d products with invalid prices", len(pRepo.created))
+	}
+
+	res, err := app.Test(jsonRequest(http.MethodPost, "/seller/products", `{"name":"Mug","category_id":1,"price":4.5,"stock":3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated || len(pRepo.created) != 1 {
+		t.Errorf("valid price: status = %d, created = %d", res.StatusCode, len(pRepo.created))
+	}
+}
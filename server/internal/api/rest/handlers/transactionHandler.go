@@ -13,6 +13,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -36,6 +37,10 @@ func SetupTransactionRoutes(rh *rest.RestHandler) {
 		Repo:   userRepo,
 		Auth:   rh.Auth,
 		PRepo:  productRepo,
+		TRepo:  transactionRepo,
+		Uow:    repository.NewUnitOfWork(rh.DB),
+		Pc:     rh.Pc,
+		Nc:     rh.Nc,
 		Config: rh.Config,
 	}
 
@@ -43,6 +48,8 @@ func SetupTransactionRoutes(rh *rest.RestHandler) {
 		Auth:   rh.Auth,
 		Config: rh.Config,
 		Repo:   transactionRepo,
+		PRepo:  productRepo,
+		Pc:     rh.Pc,
 	}
 
 	handler := TransactionHandler{
@@ -52,13 +59,22 @@ func SetupTransactionRoutes(rh *rest.RestHandler) {
 		cfg:           rh.Config,
 	}
 
-	pvtRoutes := app.Group("/transactions", rh.Auth.Authorize)
-	pvtRoutes.Get("/payment", handler.MakePayment)
+	// called by the payment gateway, authenticated by the payload signature
+	app.Post("/webhooks/payment", handler.PaymentWebhook)
+
+	pvtRoutes := app.Group("/transactions", rh.Auth.Authorize(domain.PermissionShop))
+	pvtRoutes.Get("/payment", rh.Auth.RequireVerified, handler.MakePayment)
 	pvtRoutes.Get("/payment/verify", handler.VerifyPayment)
+	pvtRoutes.Patch("/orders/:ref/status", handler.UpdateBuyerOrderStatus)
 
-	sellerRoutes := app.Group("/transactions/seller", rh.Auth.AuthorizeSeller)
+	sellerRoutes := app.Group("/transactions/seller", rh.Auth.Authorize(domain.PermissionSell))
 	sellerRoutes.Get("/orders", handler.GetOrders)
 	sellerRoutes.Get("/orders/:id", handler.GetOrderById)
+	sellerRoutes.Patch("/orders/:ref/status", handler.UpdateSellerOrderStatus)
+
+	adminRoutes := app.Group("/transactions/admin")
+	adminRoutes.Patch("/orders/:ref/status", rh.Auth.Authorize(domain.PermissionManageOrders), handler.UpdateAdminOrderStatus)
+	adminRoutes.Post("/orders/:ref/refunds", rh.Auth.Authorize(domain.PermissionRefundOrders), handler.RefundAdminOrder)
 }
 
 func (h *TransactionHandler) MakePayment(ctx *fiber.Ctx) error {
@@ -81,8 +97,16 @@ func (h *TransactionHandler) MakePayment(ctx *fiber.Ctx) error {
 		})
 	}
 
-	cartItems, amount, err := h.userSvc.FindCart(user.ID)
+	addressId, err := strconv.Atoi(ctx.Query("address_id", "0"))
+	if err != nil || addressId < 0 {
+		return rest.BadRequest(ctx, "please provide a valid address id")
+	}
+
+	cartItems, amount, err := h.userSvc.CheckoutCart(user.ID)
 	if err != nil {
+		if errors.Is(err, domain.ErrorCartNeedsReview) {
+			return rest.ConflictError(ctx, err)
+		}
 		return rest.InternalError(ctx, err)
 	}
 	if len(cartItems) == 0 {
@@ -95,8 +119,23 @@ func (h *TransactionHandler) MakePayment(ctx *fiber.Ctx) error {
 
 	}
 
+	err = h.userSvc.StartCheckout(user.ID, orderId, cartItems, amount, uint(addressId))
+	if err != nil {
+		if errors.Is(err, domain.ErrorStockNotAvailable) || errors.Is(err, domain.ErrorProductNotFound) {
+			return rest.ConflictError(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorShippingAddressRequired) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		if errors.Is(err, domain.ErrorAddressNotFound) {
+			return rest.NotFoundError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
 	paymentResult, err := h.paymentClient.CreatePayment(amount, user.ID, orderId)
 	if err != nil {
+		h.svc.ReleaseReservations(orderId)
 		return rest.InternalError(ctx, err)
 	}
 
@@ -108,6 +147,7 @@ func (h *TransactionHandler) MakePayment(ctx *fiber.Ctx) error {
 		PaymentId:    paymentResult.ID,
 	})
 	if err != nil {
+		h.svc.ReleaseReservations(orderId)
 		return rest.InternalError(ctx, err)
 	}
 
@@ -124,6 +164,11 @@ func (h *TransactionHandler) VerifyPayment(ctx *fiber.Ctx) error {
 	activePayment, err := h.svc.GetActivePayments(user.ID)
 	if err != nil || activePayment == nil {
 		if errors.Is(err, domain.ErrorUserInitialPaymentNotFound) {
+			// the webhook may already have settled the payment
+			latestPayment, latestErr := h.svc.GetLatestPayment(user.ID)
+			if latestErr == nil && latestPayment.Status == domain.PaymensStatusSuccess {
+				return rest.SuccessResponse(ctx, fiber.StatusOK, "Payment verified sucessfully", nil)
+			}
 			return rest.NotFoundError(ctx, err)
 		}
 		return rest.InternalError(ctx, err)
@@ -134,32 +179,225 @@ func (h *TransactionHandler) VerifyPayment(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
+	// intents that wait for 3DS or an async payment method are settled by
+	// the webhook once the gateway knows the outcome
+	switch paymentRes.Status {
+	case payment.IntentStatusSucceeded, payment.IntentStatusCanceled, payment.IntentStatusFailed:
+	default:
+		return rest.SuccessResponse(ctx, fiber.StatusAccepted, "Payment is being processed", map[string]interface{}{
+			"status": paymentRes.Status,
+		})
+	}
+
 	paymentJson, _ := json.Marshal(paymentRes)
-	paymentLogs := string(paymentJson)
-	paymentStatus := "failed"
-	msg := "Payment failed"
+	succeeded := paymentRes.Status == payment.IntentStatusSucceeded
+
+	err = h.settlePayment(activePayment, succeeded, string(paymentJson))
+	if err != nil {
+		if errors.Is(err, domain.ErrorStockNotAvailable) {
+			return rest.ConflictError(ctx, errors.New("some items went out of stock, your payment has been refunded"))
+		}
+		return rest.InternalError(ctx, err)
+	}
 
-	if paymentRes.Status == "succeeded" {
-		// Create order here
-		paymentStatus = "success"
+	msg := "Payment failed"
+	if succeeded {
 		msg = "Payment verified sucessfully"
-		err = h.userSvc.CreateOrder(user.ID, activePayment.OrderId, activePayment.PaymentId, activePayment.Amount)
+	}
+
+	return rest.SuccessResponse(ctx, fiber.StatusOK, msg, nil)
+
+}
+
+// PaymentWebhook is the authoritative source of payment outcomes; it settles
+// payments even when the buyer never returns to call VerifyPayment.
+func (h *TransactionHandler) PaymentWebhook(ctx *fiber.Ctx) error {
+
+	event, err := h.paymentClient.ParseWebhookEvent(ctx.Body(), http.Header(ctx.GetReqHeaders()))
+	if err != nil {
+		return rest.BadRequest(ctx, err.Error())
+	}
+
+	switch event.Type {
+	case payment.EventPaymentSucceeded, payment.EventPaymentFailed:
+
+		activePayment, err := h.svc.GetPaymentByPaymentId(event.PaymentId)
+		if err != nil {
+			return h.webhookLookupError(ctx, err)
+		}
+
+		succeeded := event.Type == payment.EventPaymentSucceeded
+		err = h.settlePayment(activePayment, succeeded, event.Raw)
+		if err != nil && !errors.Is(err, domain.ErrorStockNotAvailable) {
+			return rest.InternalError(ctx, err)
+		}
+
+	case payment.EventPaymentRefunded:
+
+		// partial refunds leave the payment and order as they are
+		if !event.FullyRefunded {
+			break
+		}
+
+		refundedPayment, err := h.svc.GetPaymentByPaymentId(event.PaymentId)
+		if err != nil {
+			return h.webhookLookupError(ctx, err)
+		}
+
+		err = h.svc.RefundPayment(refundedPayment, event.Raw)
 		if err != nil {
 			return rest.InternalError(ctx, err)
 		}
 	}
 
-	h.svc.UpdatePayment(user.ID, paymentStatus, paymentLogs)
+	return rest.SuccessResponse(ctx, fiber.StatusOK, "Webhook processed successfully", nil)
+}
 
-	return rest.SuccessResponse(ctx, fiber.StatusOK, msg, nil)
+// webhookLookupError acknowledges events for payments this service did not
+// create so the gateway stops retrying them.
+func (h *TransactionHandler) webhookLookupError(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, domain.ErrorPaymentNotFound) {
+		return rest.SuccessResponse(ctx, fiber.StatusOK, "Webhook ignored", nil)
+	}
+	return rest.InternalError(ctx, err)
+}
+
+// settlePayment records the payment outcome and creates the order on success.
+// Both steps are idempotent so it is safe to call from VerifyPayment and the
+// webhook for the same payment.
+func (h *TransactionHandler) settlePayment(p *domain.Payment, succeeded bool, paymentLog string) error {
+
+	if !succeeded {
+		err := h.svc.UpdatePayment(p, domain.PaymentStatusFailed, paymentLog)
+		if err != nil {
+			return err
+		}
+		return h.svc.ReleaseReservations(p.OrderId)
+	}
+
+	err := h.userSvc.CreateOrder(p.UserId, p.OrderId, p.PaymentId)
+	if errors.Is(err, domain.ErrorStockNotAvailable) {
+		h.svc.ReleaseReservations(p.OrderId)
+		if refundErr := h.svc.RefundUnfulfilledPayment(p, paymentLog); refundErr != nil {
+			return refundErr
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	return h.svc.UpdatePayment(p, domain.PaymensStatusSuccess, paymentLog)
+}
+
+func (h *TransactionHandler) GetOrders(ctx *fiber.Ctx) error {
+
+	filter := dto.SellerOrderFilter{}
+	if err := ctx.QueryParser(&filter); err != nil {
+		return rest.BadRequest(ctx, "please provide valid query parameters")
+	}
+
+	if filter.Status != "" && !domain.OrderStatus(filter.Status).IsValid() {
+		return rest.BadRequest(ctx, domain.ErrorInvalidOrderStatus.Error())
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	orders, pagination, err := h.svc.GetOrders(user.ID, filter)
+	if err != nil {
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.PaginatedResponse(ctx, fiber.StatusOK, "Orders fetched successfully", orders, pagination)
+}
+
+func (h *TransactionHandler) GetOrderById(ctx *fiber.Ctx) error {
+
+	orderItemId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || orderItemId < 1 {
+		return rest.BadRequest(ctx, "please provide a valid order id")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	order, err := h.svc.GetOrderById(uint(orderItemId), user.ID)
+	if err != nil {
+		if errors.Is(err, domain.ErrorOrderNotFound) {
+			return rest.NotFoundError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, fiber.StatusOK, "Order fetched successfully", order)
+}
 
+func (h *TransactionHandler) UpdateBuyerOrderStatus(ctx *fiber.Ctx) error {
+	return h.updateOrderStatus(ctx, domain.BUYER)
 }
 
-func (h *TransactionHandler) GetOrders(c *fiber.Ctx) error {
+func (h *TransactionHandler) UpdateSellerOrderStatus(ctx *fiber.Ctx) error {
+	return h.updateOrderStatus(ctx, domain.SELLER)
+}
 
-	return rest.SuccessResponse(c, fiber.StatusOK, "Orders fetched successfully", nil)
+func (h *TransactionHandler) UpdateAdminOrderStatus(ctx *fiber.Ctx) error {
+	return h.updateOrderStatus(ctx, domain.ADMIN)
 }
 
-func (h *TransactionHandler) GetOrderById(c *fiber.Ctx) error {
-	return rest.SuccessResponse(c, fiber.StatusOK, "Order fetched successfully", nil)
+func (h *TransactionHandler) updateOrderStatus(ctx *fiber.Ctx, actorRole string) error {
+
+	orderRef := ctx.Params("ref")
+	if orderRef == "" {
+		return rest.BadRequest(ctx, "please provide a valid order ref")
+	}
+
+	payload := dto.UpdateOrderStatusRequest{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide a valid request body")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	order, err := h.svc.UpdateOrderStatus(orderRef, domain.OrderStatus(payload.Status), user, actorRole, payload.Note)
+	if err != nil {
+		if errors.Is(err, domain.ErrorInvalidOrderStatus) {
+			return rest.BadRequest(ctx, err.Error())
+		} else if errors.Is(err, domain.ErrorOrderNotFound) {
+			return rest.NotFoundError(ctx, err)
+		} else if errors.Is(err, domain.ErrorInvalidOrderTransition) || errors.Is(err, domain.ErrorRefundExceedsQuantity) {
+			return rest.ConflictError(ctx, err)
+		} else if errors.Is(err, helper.NOT_AUTHORIZED_ERROR) {
+			return rest.NotAuhtorizedError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, fiber.StatusOK, "Order status updated successfully", dto.NewOrderResponse(*order))
+}
+
+func (h *TransactionHandler) RefundAdminOrder(ctx *fiber.Ctx) error {
+
+	orderRef := ctx.Params("ref")
+	if orderRef == "" {
+		return rest.BadRequest(ctx, "please provide a valid order ref")
+	}
+
+	payload := dto.RefundRequest{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide a valid request body")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	order, err := h.svc.RefundOrder(orderRef, payload, user)
+	if err != nil {
+		if errors.Is(err, domain.ErrorOrderNotFound) || errors.Is(err, domain.ErrorOrderItemNotFound) {
+			return rest.NotFoundError(ctx, err)
+		} else if errors.Is(err, domain.ErrorRefundExceedsQuantity) || errors.Is(err, domain.ErrorNothingToRefund) {
+			return rest.BadRequest(ctx, err.Error())
+		} else if errors.Is(err, domain.ErrorInvalidOrderTransition) {
+			return rest.ConflictError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, fiber.StatusOK, "Order refunded successfully", dto.NewOrderResponse(*order))
 }
@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"bytes"
+	"ecommerce/internal/domain"
+	"ecommerce/internal/dto"
+	"ecommerce/internal/repository"
+	"ecommerce/internal/service"
+	"ecommerce/pkg/money"
+	"ecommerce/pkg/payment"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// stubTransactionRepo keeps one payment, one checkout snapshot and one order
+// in memory. Methods the tests do not use panic through the nil embedded
+// interface.
+type stubTransactionRepo struct {
+	repository.TransactionRepository
+	payment  domain.Payment
+	snapshot domain.CheckoutSnapshot
+	order    domain.Order
+	// restocked sums the quantities put back in stock per product
+	restocked    map[uint]uint
+	sellerOrders []dto.SellerOrderDetails
+}
+
+func (r *stubTransactionRepo) FindPaymentByPaymentId(pId string) (*domain.Payment, error) {
+	if pId != r.payment.PaymentId {
+		return nil, domain.ErrorPaymentNotFound
+	}
+	p := r.payment
+	return &p, nil
+}
+
+func (r *stubTransactionRepo) UpdatePaymentStatus(p *domain.Payment, status domain.PaymentStatus, response string) error {
+	r.payment.Status = status
+	p.Status = status
+	return nil
+}
+
+func (r *stubTransactionRepo) FindCheckoutSnapshot(orderRef string) (*domain.CheckoutSnapshot, error) {
+	if orderRef != r.snapshot.OrderRef {
+		return nil, domain.ErrorCheckoutSnapshotNotFound
+	}
+	s := r.snapshot
+	return &s, nil
+}
+
+func (r *stubTransactionRepo) FindOrderByRef(ref string) (*domain.Order, error) {
+	if ref != r.order.OrderRef {
+		return nil, domain.ErrorOrderNotFound
+	}
+	o := r.order
+	o.Items = append([]domain.OrderItem(nil), r.order.Items...)
+	o.History = append([]domain.OrderStatusHistory(nil), r.order.History...)
+	o.Refunds = append([]domain.Refund(nil), r.order.Refunds...)
+	return &o, nil
+}
+
+func (r *stubTransactionRepo) UpdateOrderStatus(order *domain.Order, history domain.OrderStatusHistory) error {
+	if r.order.Status != history.FromStatus {
+		return domain.OrderTransitionError{From: history.FromStatus, To: history.ToStatus}
+	}
+	history.ID = uint(len(r.order.History) + 1)
+	r.order.Status = history.ToStatus
+	r.order.History = append(r.order.History, history)
+	order.Status = history.ToStatus
+	return nil
+}
+
+func (r *stubTransactionRepo) ReserveRefunds(refunds []domain.Refund) error {
+	for _, refund := range refunds {
+		for _, item := range r.order.Items {
+			if item.ID == refund.OrderItemId && item.RefundedQty+refund.Qty > item.Qty {
+				return domain.ErrorRefundExceedsQuantity
+			}
+		}
+	}
+	for i := range refunds {
+		for j := range r.order.Items {
+			if r.order.Items[j].ID == refunds[i].OrderItemId {
+				r.order.Items[j].RefundedQty += refunds[i].Qty
+			}
+		}
+		refunds[i].ID = uint(len(r.order.Refunds) + 1)
+		refunds[i].Status = domain.RefundStatusPending
+		r.order.Refunds = append(r.order.Refunds, refunds[i])
+	}
+	return nil
+}
+
+func (r *stubTransactionRepo) CompleteRefunds(refunds []domain.Refund, gatewayRefundId string, p *domain.Payment, paymentStatus domain.PaymentStatus, order *domain.Order, history *domain.OrderStatusHistory) error {
+	if history != nil && r.order.Status != history.FromStatus {
+		return domain.OrderTransitionError{From: history.FromStatus, To: history.ToStatus}
+	}
+	for _, refund := range refunds {
+		stored := &r.order.Refunds[refund.ID-1]
+		if stored.Status != domain.RefundStatusPending {
+			continue
+		}
+		stored.Status = domain.RefundStatusSucceeded
+		stored.GatewayRefundId = gatewayRefundId
+		if r.restocked == nil {
+			r.restocked = map[uint]uint{}
+		}
+		r.restocked[refund.ProductId] += refund.Qty
+	}
+	r.payment.Status = paymentStatus
+	p.Status = paymentStatus
+	if history != nil {
+		return r.UpdateOrderStatus(order, *history)
+	}
+	return nil
+}
+
+type stubUserRepo struct {
+	repository.UserRepository
+	user   domain.User
+	orders []domain.Order
+	cart   []domain.Cart
+}
+
+func (r *stubUserRepo) FindUserOrderById(id string, uId uint) (domain.Order, error) {
+	for _, o := range r.orders {
+		if o.OrderRef == id && o.UserId == uId {
+			return o, nil
+		}
+	}
+	return domain.Order{}, domain.ErrorOrderNotFound
+}
+
+func (r *stubUserRepo) CreateOrder(o domain.Order) error {
+	r.orders = append(r.orders, o)
+	return nil
+}
+
+func (r *stubUserRepo) DeleteCartProducts(uId uint, productIds []uint) error {
+	return nil
+}
+
+type stubProductRepo struct {
+	repository.ProductRepository
+	reservations map[string]domain.ReservationStatus
+	created      []domain.Product
+}
+
+func (r *stubProductRepo) UpdateReservations(orderRef string, from, to domain.ReservationStatus) error {
+	if r.reservations[orderRef] == from {
+		r.reservations[orderRef] = to
+	}
+	return nil
+}
+
+func (r *stubProductRepo) DecrementStock(id uint, qty uint) error {
+	return nil
+}
+
+type stubUnitOfWork struct {
+	repos repository.Repositories
+}
+
+func (u stubUnitOfWork) Do(fn func(repos repository.Repositories) error) error {
+	return fn(u.repos)
+}
+
+type webhookFixture struct {
+	app      *fiber.App
+	tRepo    *stubTransactionRepo
+	userRepo *stubUserRepo
+	pRepo    *stubProductRepo
+}
+
+func newWebhookFixture(t *testing.T) webhookFixture {
+
+	t.Helper()
+
+	f := webhookFixture{
+		tRepo: &stubTransactionRepo{
+			payment: domain.Payment{ID: 1, UserId: 7, PaymentId: "pi_fixture", OrderId: "ORDER1", Status: domain.PaymentStatusInitial},
+			snapshot: domain.CheckoutSnapshot{
+				UserId:   7,
+				OrderRef: "ORDER1",
+				Amount:   money.New(1000, "USD"),
+				Items:    []domain.CheckoutSnapshotItem{{ProductId: 3, SellerId: 2, Price: money.New(500, "USD"), Qty: 2}},
+			},
+		},
+		userRepo: &stubUserRepo{},
+		pRepo:    &stubProductRepo{reservations: map[string]domain.ReservationStatus{"ORDER1": domain.ReservationStatusActive}},
+	}
+
+	pc := payment.NewFakePaymentClient(testWebhookSecret)
+
+	handler := TransactionHandler{
+		svc: service.TransactionService{
+			Repo:  f.tRepo,
+			PRepo: f.pRepo,
+			Pc:    pc,
+		},
+		userSvc: service.UserService{
+			Repo:  f.userRepo,
+			PRepo: f.pRepo,
+			TRepo: f.tRepo,
+			Uow:   stubUnitOfWork{repos: repository.Repositories{User: f.userRepo, Product: f.pRepo, Transaction: f.tRepo}},
+			Pc:    pc,
+		},
+		paymentClient: pc,
+	}
+
+	f.app = fiber.New()
+	f.app.Post("/webhooks/payment", handler.PaymentWebhook)
+
+	return f
+}
+
+// signedFixture loads a webhook fixture from testdata and signs it with
+// secret the way the fake provider expects.
+func signedFixture(t *testing.T, name string, secret string) *http.Request {
+
+	t.Helper()
+
+	payload, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/payment", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range payment.SignFakeWebhook(payload, secret) {
+		req.Header[key] = values
+	}
+
+	return req
+}
+
+func TestPaymentWebhookSucceeded(t *testing.T) {
+
+	f := newWebhookFixture(t)
+
+	res, err := f.app.Test(signedFixture(t, "payment_succeeded.json", testWebhookSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if f.tRepo.payment.Status != domain.PaymensStatusSuccess {
+		t.Errorf("payment status = %s, want %s", f.tRepo.payment.Status, domain.PaymensStatusSuccess)
+	}
+	if len(f.userRepo.orders) != 1 || f.userRepo.orders[0].OrderRef != "ORDER1" {
+		t.Fatalf("orders = %+v, want one order ORDER1", f.userRepo.orders)
+	}
+	if f.pRepo.reservations["ORDER1"] != domain.ReservationStatusConverted {
+		t.Errorf("reservation = %s, want %s", f.pRepo.reservations["ORDER1"], domain.ReservationStatusConverted)
+	}
+
+	// the gateway retries deliveries, a repeated event must not create a second order
+	res, err = f.app.Test(signedFixture(t, "payment_succeeded.json", testWebhookSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(f.userRepo.orders) != 1 {
+		t.Errorf("redelivery: status = %d, orders = %d", res.StatusCode, len(f.userRepo.orders))
+	}
+}
+
+func TestPaymentWebhookFailed(t *testing.T) {
+
+	f := newWebhookFixture(t)
+
+	res, err := f.app.Test(signedFixture(t, "payment_failed.json", testWebhookSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if f.tRepo.payment.Status != domain.PaymentStatusFailed {
+		t.Errorf("payment status = %s, want %s", f.tRepo.payment.Status, domain.PaymentStatusFailed)
+	}
+	if len(f.userRepo.orders) != 0 {
+		t.Errorf("orders = %d, want none", len(f.userRepo.orders))
+	}
+	if f.pRepo.reservations["ORDER1"] != domain.ReservationStatusReleased {
+		t.Errorf("reservation = %s, want %s", f.pRepo.reservations["ORDER1"], domain.ReservationStatusReleased)
+	}
+}
+
+func TestPaymentWebhookRejectsBadSignature(t *testing.T) {
+
+	f := newWebhookFixture(t)
+
+	res, err := f.app.Test(signedFixture(t, "payment_succeeded.json", "not-the-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+
+	if f.tRepo.payment.Status != domain.PaymentStatusInitial || len(f.userRepo.orders) != 0 {
+		t.Errorf("unsigned event was processed: payment %s, orders %d", f.tRepo.payment.Status, len(f.userRepo.orders))
+	}
+}
+
+// paidOrder is order ORDER1 of the fixture payment, paid and not refunded.
+func paidOrder() domain.Order {
+	return domain.Order{
+		ID:        1,
+		UserId:    7,
+		Status:    domain.OrderStatusPaid,
+		Amount:    money.New(1000, "USD"),
+		OrderRef:  "ORDER1",
+		PaymentId: "pi_fixture",
+		Items:     []domain.OrderItem{{ID: 1, OrderId: 1, ProductId: 3, SellerId: 2, Price: money.New(500, "USD"), Qty: 2}},
+	}
+}
+
+func TestPaymentWebhookRefundedOnGateway(t *testing.T) {
+
+	f := newWebhookFixture(t)
+	f.tRepo.payment.Status = domain.PaymensStatusSuccess
+	f.tRepo.order = paidOrder()
+
+	res, err := f.app.Test(signedFixture(t, "payment_refunded.json", testWebhookSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if f.tRepo.order.Status != domain.OrderStatusRefunded {
+		t.Errorf("order status = %s, want %s", f.tRepo.order.Status, domain.OrderStatusRefunded)
+	}
+	if len(f.tRepo.order.Refunds) != 1 || f.tRepo.order.Refunds[0].Status != domain.RefundStatusSucceeded || f.tRepo.order.Refunds[0].Qty != 2 {
+		t.Errorf("refunds = %+v, want one succeeded refund of 2", f.tRepo.order.Refunds)
+	}
+	if f.tRepo.restocked[3] != 2 {
+		t.Errorf("restocked = %d, want 2", f.tRepo.restocked[3])
+	}
+}
+
+// The gateway reports a refund issued through RefundOrder before the refund
+// has been completed; the order is left for RefundOrder to move.
+func TestPaymentWebhookRefundedWhileRefundPending(t *testing.T) {
+
+	f := newWebhookFixture(t)
+	f.tRepo.payment.Status = domain.PaymensStatusSuccess
+	f.tRepo.order = paidOrder()
+	f.tRepo.order.Items[0].RefundedQty = 2
+	f.tRepo.order.Refunds = []domain.Refund{{ID: 1, PaymentId: 1, OrderId: 1, OrderItemId: 1, ProductId: 3, Qty: 2, Status: domain.RefundStatusPending}}
+
+	res, err := f.app.Test(signedFixture(t, "payment_refunded.json", testWebhookSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if f.tRepo.order.Status != domain.OrderStatusPaid || len(f.tRepo.order.History) != 0 {
+		t.Errorf("order moved to %s, want it left %s", f.tRepo.order.Status, domain.OrderStatusPaid)
+	}
+	if f.tRepo.order.Refunds[0].Status != domain.RefundStatusPending || len(f.tRepo.restocked) != 0 {
+		t.Errorf("pending refund was completed by the webhook: %+v", f.tRepo.order.Refunds[0])
+	}
+}
+
+type orderFixture struct {
+	app   *fiber.App
+	tRepo *stubTransactionRepo
+}
+
+// newOrderFixture serves the order endpoints for actor against order, which
+// is paid through the fake gateway.
+func newOrderFixture(t *testing.T, order domain.Order, actor domain.User) orderFixture {
+
+	t.Helper()
+
+	pc := payment.NewFakePaymentClient(testWebhookSecret)
+	intent, err := pc.CreatePayment(order.Amount, order.UserId, order.OrderRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.GetPaymentStatus(intent.ID); err != nil {
+		t.Fatal(err)
+	}
+	order.PaymentId = intent.ID
+
+	f := orderFixture{
+		tRepo: &stubTransactionRepo{
+			payment: domain.Payment{ID: 1, UserId: order.UserId, PaymentId: intent.ID, OrderId: order.OrderRef, Amount: order.Amount, Status: domain.PaymensStatusSuccess},
+			order:   order,
+		},
+	}
+
+	handler := TransactionHandler{
+		svc: service.TransactionService{
+			Repo:  f.tRepo,
+			PRepo: &stubProductRepo{},
+			Pc:    pc,
+		},
+		paymentClient: pc,
+	}
+
+	f.app = fiber.New()
+	f.app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("user", actor)
+		return ctx.Next()
+	})
+	f.app.Patch("/transactions/seller/orders/:ref/status", handler.UpdateSellerOrderStatus)
+	f.app.Patch("/transactions/admin/orders/:ref/status", handler.UpdateAdminOrderStatus)
+	f.app.Post("/transactions/admin/orders/:ref/refunds", handler.RefundAdminOrder)
+
+	return f
+}
+
+func jsonRequest(method string, path string, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestRefundAdminOrderPartially(t *testing.T) {
+
+	admin := domain.User{ID: 9, UserType: domain.ADMIN}
+	f := newOrderFixture(t, paidOrder(), admin)
+
+	res, err := f.app.Test(jsonRequest(http.MethodPost, "/transactions/admin/orders/ORDER1/refunds", `{"items":[{"order_item_id":1,"qty":1}],"reason":"damaged"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	order := f.tRepo.order
+	if order.Status != domain.OrderStatusPartiallyRefunded {
+		t.Errorf("order status = %s, want %s", order.Status, domain.OrderStatusPartiallyRefunded)
+	}
+	if len(order.History) != 1 || order.History[0].FromStatus != domain.OrderStatusPaid || order.History[0].ToStatus != domain.OrderStatusPartiallyRefunded || order.History[0].ActorRole != domain.ADMIN {
+		t.Errorf("history = %+v, want paid -> partially_refunded by admin", order.History)
+	}
+	if f.tRepo.payment.Status != domain.PaymentStatusPartiallyRefunded {
+		t.Errorf("payment status = %s, want %s", f.tRepo.payment.Status, domain.PaymentStatusPartiallyRefunded)
+	}
+	if f.tRepo.restocked[3] != 1 {
+		t.Errorf("restocked = %d, want 1", f.tRepo.restocked[3])
+	}
+
+	// the rest of the order is still fulfilled from where it was
+	seller := newOrderFixture(t, f.tRepo.order, domain.User{ID: 2, UserType: domain.SELLER})
+	res, err = seller.app.Test(jsonRequest(http.MethodPatch, "/transactions/seller/orders/ORDER1/status", `{"status":"processing"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || seller.tRepo.order.Status != domain.OrderStatusProcessing {
+		t.Errorf("seller processing: status = %d, order = %s", res.StatusCode, seller.tRepo.order.Status)
+	}
+
+	// refunding what is left finishes the order
+	res, err = f.app.Test(jsonRequest(http.MethodPost, "/transactions/admin/orders/ORDER1/refunds", `{"reason":"damaged"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("second refund: status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if f.tRepo.order.Status != domain.OrderStatusRefunded || len(f.tRepo.order.History) != 2 {
+		t.Errorf("order = %s with %d history entries, want refunded with 2", f.tRepo.order.Status, len(f.tRepo.order.History))
+	}
+	if f.tRepo.payment.Status != domain.PaymentStatusRefunded || f.tRepo.restocked[3] != 2 {
+		t.Errorf("payment = %s, restocked = %d, want refunded and 2", f.tRepo.payment.Status, f.tRepo.restocked[3])
+	}
+}
+
+// An order with items of two sellers cannot be moved by either seller alone,
+// an admin fulfils it.
+func TestUpdateOrderStatusTwoSellers(t *testing.T) {
+
+	order := paidOrder()
+	order.Items = append(order.Items, domain.OrderItem{ID: 2, OrderId: 1, ProductId: 4, SellerId: 5, Price: money.New(250, "USD"), Qty: 1})
+
+	for _, sellerId := range []uint{2, 5} {
+		f := newOrderFixture(t, order, domain.User{ID: sellerId, UserType: domain.SELLER})
+		res, err := f.app.Test(jsonRequest(http.MethodPatch, "/transactions/seller/orders/ORDER1/status", `{"status":"processing"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusNotFound || f.tRepo.order.Status != domain.OrderStatusPaid {
+			t.Errorf("seller %d: status = %d, order = %s, want 404 and paid", sellerId, res.StatusCode, f.tRepo.order.Status)
+		}
+	}
+
+	f := newOrderFixture(t, order, domain.User{ID: 9, UserType: domain.ADMIN})
+	for _, next := range []domain.OrderStatus{domain.OrderStatusProcessing, domain.OrderStatusShipped, domain.OrderStatusDelivered} {
+		res, err := f.app.Test(jsonRequest(http.MethodPatch, "/transactions/admin/orders/ORDER1/status", `{"status":"`+string(next)+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || f.tRepo.order.Status != next {
+			t.Fatalf("admin %s: status = %d, order = %s", next, res.StatusCode, f.tRepo.order.Status)
+		}
+	}
+	if last := f.tRepo.order.History[len(f.tRepo.order.History)-1]; last.ActorRole != domain.ADMIN || last.ActorId != 9 {
+		t.Errorf("history = %+v, want the admin as actor", last)
+	}
+}
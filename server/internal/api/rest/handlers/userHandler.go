@@ -6,8 +6,11 @@ import (
 	"ecommerce/internal/dto"
 	"ecommerce/internal/repository"
 	"ecommerce/internal/service"
+	"ecommerce/pkg/notification"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -24,6 +27,12 @@ func SetupUserRoutes(rh *rest.RestHandler) {
 	svc := service.UserService{
 		Repo:   repository.NewUserRepository(rh.DB),
 		PRepo:  repository.NewProductRepository(rh.DB),
+		TRepo:  repository.NewTransactionRepository(rh.DB),
+		SRepo:  repository.NewSessionRepository(rh.DB),
+		ThRepo: repository.NewThrottleRepository(rh.DB),
+		Uow:    repository.NewUnitOfWork(rh.DB),
+		Pc:     rh.Pc,
+		Nc:     rh.Nc,
 		Auth:   rh.Auth,
 		Config: rh.Config,
 	}
@@ -36,10 +45,16 @@ func SetupUserRoutes(rh *rest.RestHandler) {
 
 	pubRoutes.Post("/register", handler.register)
 	pubRoutes.Post("/login", handler.login)
+	pubRoutes.Post("/login/2fa", handler.loginTwoFactor)
+	pubRoutes.Post("/auth/refresh", handler.refresh)
+	pubRoutes.Post("/password/forgot", handler.forgotPassword)
+	pubRoutes.Post("/password/reset", handler.resetPassword)
+	pubRoutes.Post("/logout", rh.Auth.Authorize(), handler.logout)
+	app.Get("/.well-known/jwks.json", rh.Auth.JWKS)
 
 	// Private endpoints
 
-	pvtRoutes := pubRoutes.Group("/users", rh.Auth.Authorize)
+	pvtRoutes := pubRoutes.Group("/users", rh.Auth.Authorize())
 
 	pvtRoutes.Get("/verify", handler.getVerificationCode)
 	pvtRoutes.Post("/verify", handler.verify)
@@ -48,13 +63,32 @@ func SetupUserRoutes(rh *rest.RestHandler) {
 	pvtRoutes.Post("/profile", handler.createProfile)
 	pvtRoutes.Patch("/profile", handler.updateProfile)
 
+	pvtRoutes.Get("/addresses", handler.getAddresses)
+	pvtRoutes.Post("/addresses", handler.addAddress)
+	pvtRoutes.Patch("/addresses/:id", handler.updateAddress)
+	pvtRoutes.Delete("/addresses/:id", handler.deleteAddress)
+	pvtRoutes.Patch("/password", handler.changePassword)
+	pvtRoutes.Patch("/email", handler.changeEmail)
+
+	pvtRoutes.Post("/2fa/enroll", handler.enrollTwoFactor)
+	pvtRoutes.Post("/2fa/confirm", handler.confirmTwoFactor)
+	pvtRoutes.Post("/2fa/disable", handler.disableTwoFactor)
+
 	pvtRoutes.Get("/cart", handler.getCart)
 	pvtRoutes.Post("/cart", handler.addToCart)
+	pvtRoutes.Post("/cart/acknowledge", handler.acknowledgeCart)
 
 	pvtRoutes.Get("/order", handler.getOrders)
 	pvtRoutes.Get("/order/:id", handler.getOrder)
 
-	pvtRoutes.Post("/become-seller", handler.becomeSeller)
+	pvtRoutes.Post("/become-seller", rh.Auth.RequireVerified, handler.becomeSeller)
+
+	// Admin endpoints
+
+	adminRoutes := app.Group("/admin/users", rh.Auth.Authorize(domain.PermissionModerateUsers))
+
+	adminRoutes.Patch("/:id/suspension", handler.suspendUser)
+	adminRoutes.Patch("/:id/role", handler.changeUserRole)
 
 }
 
@@ -69,7 +103,7 @@ func (h UserHandler) register(ctx *fiber.Ctx) error {
 		})
 	}
 
-	token, err := h.svc.SignUp(user)
+	tokens, err := h.svc.SignUp(user)
 	if err != nil {
 		return ctx.Status(http.StatusInternalServerError).JSON(&fiber.Map{
 			"message": "error on signup",
@@ -78,8 +112,10 @@ func (h UserHandler) register(ctx *fiber.Ctx) error {
 	}
 
 	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
-		"message": "user signup sucessfull",
-		"token":   token,
+		"message":       "user signup sucessfull",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 
 }
@@ -95,26 +131,175 @@ func (h UserHandler) login(ctx *fiber.Ctx) error {
 		})
 	}
 
-	token, err := h.svc.Login(loginInInput.Email, loginInInput.Password)
+	tokens, challenge, err := h.svc.Login(loginInInput.Email, loginInInput.Password, ctx.IP())
 	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorUserSuspended) {
+			return rest.NotAuhtorizedError(ctx, err)
+		}
 		return ctx.Status(http.StatusBadRequest).JSON(&fiber.Map{
 			"message": "please provide correct user email and password",
 		})
 	}
 
+	if challenge != nil {
+		return ctx.Status(http.StatusOK).JSON(&fiber.Map{
+			"message":         "two factor authentication required",
+			"challenge_token": challenge.ChallengeToken,
+			"expires_in":      challenge.ExpiresIn,
+		})
+	}
+
+	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":       "user login sucessfull",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+
+}
+
+func (h UserHandler) loginTwoFactor(ctx *fiber.Ctx) error {
+
+	payload := dto.TwoFactorLoginInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	tokens, err := h.svc.LoginTwoFactor(payload.ChallengeToken, payload.Code)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorInvalidTwoFactorCode) || errors.Is(err, domain.ErrorTwoFactorNotEnabled) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		if errors.Is(err, domain.ErrorUserSuspended) {
+			return rest.NotAuhtorizedError(ctx, err)
+		}
+		return ctx.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+			"message": "authorization failed",
+			"reason":  err.Error(),
+		})
+	}
+
+	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":       "user login sucessfull",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+
+}
+
+func (h UserHandler) refresh(ctx *fiber.Ctx) error {
+
+	payload := dto.RefreshTokenInput{}
+	err := ctx.BodyParser(&payload)
+	if err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	tokens, err := h.svc.RefreshSession(payload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, domain.ErrorInvalidRefreshToken) ||
+			errors.Is(err, domain.ErrorRefreshTokenReused) ||
+			errors.Is(err, domain.ErrorSessionRevoked) ||
+			errors.Is(err, domain.ErrorUserSuspended) {
+			return ctx.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+				"message": "authorization failed",
+				"reason":  err.Error(),
+			})
+		}
+		return rest.InternalError(ctx, err)
+	}
+
 	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
-		"message": "user login sucessfull",
-		"token":   token,
+		"message":       "token refreshed sucessfully",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 
 }
 
+func (h UserHandler) forgotPassword(ctx *fiber.Ctx) error {
+
+	payload := dto.ForgotPasswordInput{}
+	err := ctx.BodyParser(&payload)
+	if err != nil || payload.Email == "" {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	channel, err := notification.ParseChannel(payload.Channel)
+	if err != nil {
+		return rest.BadRequest(ctx, err.Error())
+	}
+
+	err = h.svc.RequestPasswordReset(payload.Email, channel, ctx.IP())
+	if err != nil {
+		if errors.Is(err, notification.ErrorChannelUnavailable) {
+			return rest.BadRequest(ctx, err.Error())
+		} else if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "if the account exists, a password reset code has been sent", nil)
+
+}
+
+func (h UserHandler) resetPassword(ctx *fiber.Ctx) error {
+
+	payload := dto.ResetPasswordInput{}
+	err := ctx.BodyParser(&payload)
+	if err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	err = h.svc.ResetPassword(payload.Token, payload.Password)
+	if err != nil {
+		if errors.Is(err, domain.ErrorInvalidResetToken) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "password has been reset, please login again", nil)
+
+}
+
+func (h UserHandler) logout(ctx *fiber.Ctx) error {
+
+	err := h.svc.Logout(h.svc.Auth.GetCurrentSessionId(ctx))
+	if err != nil {
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "logged out successfully", nil)
+
+}
+
 func (h UserHandler) getVerificationCode(ctx *fiber.Ctx) error {
 
 	user := h.svc.Auth.GetCurrentUser(ctx)
 
-	err := h.svc.GetVerificationCode(user)
+	channel, err := notification.ParseChannel(ctx.Query("channel"))
 	if err != nil {
+		return rest.BadRequest(ctx, err.Error())
+	}
+
+	err = h.svc.GetVerificationCode(user, channel)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, notification.ErrorChannelUnavailable) || errors.Is(err, domain.ErrorNoContactNumber) {
+			return rest.BadRequest(ctx, err.Error())
+		}
 		return ctx.Status(http.StatusInternalServerError).JSON(&fiber.Map{
 			"message": "verification code generated",
 			"error":   err.Error(),
@@ -122,7 +307,7 @@ func (h UserHandler) getVerificationCode(ctx *fiber.Ctx) error {
 	}
 
 	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
-		"message": "verification code has been sent your contact number",
+		"message": "verification code has been sent",
 	})
 
 }
@@ -141,6 +326,9 @@ func (h UserHandler) verify(ctx *fiber.Ctx) error {
 
 	err = h.svc.VerifyCode(user.ID, payload.Code)
 	if err != nil {
+		if errors.Is(err, domain.ErrorVerificationCodeExhausted) {
+			return rest.ErrorMessage(ctx, http.StatusTooManyRequests, err)
+		}
 		return ctx.Status(http.StatusBadRequest).JSON(&fiber.Map{
 			"message": "error verifying the code",
 			"reason":  err.Error(),
@@ -165,7 +353,7 @@ func (h UserHandler) getProfile(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Profile fetched sucessfully", profile)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Profile fetched sucessfully", dto.NewProfileResponse(*profile))
 
 }
 
@@ -186,6 +374,9 @@ func (h UserHandler) updateProfile(ctx *fiber.Ctx) error {
 		if errors.Is(err, domain.ErrorUserNotFound) {
 			return rest.NotFoundError(ctx, err)
 		}
+		if errors.Is(err, domain.ErrorInvalidAddress) {
+			return rest.BadRequest(ctx, err.Error())
+		}
 		return rest.InternalError(ctx, err)
 	}
 
@@ -193,6 +384,139 @@ func (h UserHandler) updateProfile(ctx *fiber.Ctx) error {
 
 }
 
+func (h UserHandler) changePassword(ctx *fiber.Ctx) error {
+
+	payload := dto.ChangePasswordInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	tokens, err := h.svc.ChangePassword(user.ID, h.svc.Auth.GetCurrentSessionId(ctx), payload)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorIncorrectPassword) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":       "password changed sucessfully",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+
+}
+
+func (h UserHandler) changeEmail(ctx *fiber.Ctx) error {
+
+	payload := dto.ChangeEmailInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	tokens, err := h.svc.ChangeEmail(user.ID, h.svc.Auth.GetCurrentSessionId(ctx), payload)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorIncorrectPassword) || errors.Is(err, domain.ErrorInvalidEmail) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		if errors.Is(err, domain.ErrorEmailAlreadyInUse) {
+			return rest.ConflictError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
+		"message":       "email changed sucessfully, please verify your account again",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+
+}
+
+func (h UserHandler) enrollTwoFactor(ctx *fiber.Ctx) error {
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	enrollment, err := h.svc.EnrollTwoFactor(user.ID)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTwoFactorAlreadyEnabled) {
+			return rest.ConflictError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "scan the provisioning uri and confirm with a code", enrollment)
+
+}
+
+func (h UserHandler) confirmTwoFactor(ctx *fiber.Ctx) error {
+
+	payload := dto.TwoFactorCodeInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	confirmation, err := h.svc.ConfirmTwoFactor(user.ID, payload.Code)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorTwoFactorAlreadyEnabled) {
+			return rest.ConflictError(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorTwoFactorNotEnrolled) || errors.Is(err, domain.ErrorInvalidTwoFactorCode) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "two factor authentication enabled, store the recovery codes safely", confirmation)
+
+}
+
+func (h UserHandler) disableTwoFactor(ctx *fiber.Ctx) error {
+
+	payload := dto.DisableTwoFactorInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	err := h.svc.DisableTwoFactor(user.ID, payload)
+	if err != nil {
+		if errors.Is(err, domain.ErrorTooManyAttempts) {
+			return tooManyAttempts(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorTwoFactorRequired) {
+			return rest.NotAuhtorizedError(ctx, err)
+		}
+		if errors.Is(err, domain.ErrorTwoFactorNotEnabled) ||
+			errors.Is(err, domain.ErrorIncorrectPassword) ||
+			errors.Is(err, domain.ErrorInvalidTwoFactorCode) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "two factor authentication disabled", nil)
+
+}
+
 func (h UserHandler) createProfile(ctx *fiber.Ctx) error {
 
 	payload := dto.ProfileInput{}
@@ -207,6 +531,9 @@ func (h UserHandler) createProfile(ctx *fiber.Ctx) error {
 		if errors.Is(err, domain.ErrorUserNotFound) {
 			return rest.NotFoundError(ctx, err)
 		}
+		if errors.Is(err, domain.ErrorInvalidAddress) {
+			return rest.BadRequest(ctx, err.Error())
+		}
 		return rest.InternalError(ctx, err)
 	}
 
@@ -214,6 +541,87 @@ func (h UserHandler) createProfile(ctx *fiber.Ctx) error {
 
 }
 
+func (h UserHandler) getAddresses(ctx *fiber.Ctx) error {
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	addresses, err := h.svc.GetAddresses(user.ID)
+	if err != nil {
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Addresses fetched sucessfully", dto.NewAddressResponses(addresses))
+
+}
+
+func (h UserHandler) addAddress(ctx *fiber.Ctx) error {
+
+	payload := dto.AddressInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	address, err := h.svc.AddAddress(user.ID, payload)
+	if err != nil {
+		if errors.Is(err, domain.ErrorInvalidAddress) {
+			return rest.BadRequest(ctx, err.Error())
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusCreated, "Address added sucessfully", dto.NewAddressResponse(*address))
+
+}
+
+func (h UserHandler) updateAddress(ctx *fiber.Ctx) error {
+
+	addressId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || addressId < 0 {
+		return rest.BadRequest(ctx, "please provide a valid address id")
+	}
+
+	payload := dto.AddressInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	address, err := h.svc.UpdateAddress(user.ID, uint(addressId), payload)
+	if err != nil {
+		if errors.Is(err, domain.ErrorAddressNotFound) {
+			return rest.NotFoundError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Address updated sucessfully", dto.NewAddressResponse(*address))
+
+}
+
+func (h UserHandler) deleteAddress(ctx *fiber.Ctx) error {
+
+	addressId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || addressId < 0 {
+		return rest.BadRequest(ctx, "please provide a valid address id")
+	}
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	err = h.svc.DeleteAddress(user.ID, uint(addressId))
+	if err != nil {
+		if errors.Is(err, domain.ErrorAddressNotFound) {
+			return rest.NotFoundError(ctx, err)
+		}
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Address deleted sucessfully", nil)
+
+}
+
 func (h UserHandler) addToCart(ctx *fiber.Ctx) error {
 
 	paylaod := dto.CreateCartRequest{}
@@ -231,7 +639,20 @@ func (h UserHandler) addToCart(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Item added to cart successfully", cartItems)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Item added to cart successfully", dto.NewCartResponse(cartItems))
+
+}
+
+func (h UserHandler) acknowledgeCart(ctx *fiber.Ctx) error {
+
+	user := h.svc.Auth.GetCurrentUser(ctx)
+
+	cart, err := h.svc.AcknowledgeCart(user.ID)
+	if err != nil {
+		return rest.InternalError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "Cart changes acknowledged", dto.NewCartResponse(cart))
 
 }
 
@@ -244,7 +665,7 @@ func (h UserHandler) getCart(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Cart fetched sucessfully", cart)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Cart fetched sucessfully", dto.NewCartResponse(cart))
 
 }
 
@@ -256,7 +677,7 @@ func (h UserHandler) getOrders(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Orders fetched sucessfully", orders)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Orders fetched sucessfully", dto.NewOrderResponses(orders))
 
 }
 
@@ -277,7 +698,7 @@ func (h UserHandler) getOrder(ctx *fiber.Ctx) error {
 		return rest.InternalError(ctx, err)
 	}
 
-	return rest.SuccessResponse(ctx, http.StatusOK, "Order fetched sucessfully", order)
+	return rest.SuccessResponse(ctx, http.StatusOK, "Order fetched sucessfully", dto.NewOrderResponse(order))
 
 }
 
@@ -293,7 +714,7 @@ func (h UserHandler) becomeSeller(ctx *fiber.Ctx) error {
 		})
 	}
 
-	token, err := h.svc.BecomeSeller(user.ID, payload)
+	tokens, err := h.svc.BecomeSeller(user.ID, payload)
 	if err != nil {
 		return ctx.Status(http.StatusBadRequest).JSON(&fiber.Map{
 			"message": "error occurred while updating to seller status",
@@ -302,8 +723,81 @@ func (h UserHandler) becomeSeller(ctx *fiber.Ctx) error {
 	}
 
 	return ctx.Status(http.StatusOK).JSON(&fiber.Map{
-		"message": "updated to seller successfully",
-		"token":   token,
+		"message":       "updated to seller successfully",
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 
 }
+
+func (h UserHandler) suspendUser(ctx *fiber.Ctx) error {
+
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || id < 1 {
+		return rest.BadRequest(ctx, "please provide a valid user id")
+	}
+
+	payload := dto.SuspendUserInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	admin := h.svc.Auth.GetCurrentUser(ctx)
+
+	err = h.svc.SuspendUser(admin.ID, uint(id), payload.Suspended)
+	if err != nil {
+		return moderationError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "user suspension updated", nil)
+
+}
+
+func (h UserHandler) changeUserRole(ctx *fiber.Ctx) error {
+
+	id, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil || id < 1 {
+		return rest.BadRequest(ctx, "please provide a valid user id")
+	}
+
+	payload := dto.ChangeRoleInput{}
+	if err := ctx.BodyParser(&payload); err != nil {
+		return rest.BadRequest(ctx, "please provide valid input")
+	}
+
+	admin := h.svc.Auth.GetCurrentUser(ctx)
+
+	err = h.svc.ChangeUserRole(admin.ID, uint(id), payload.Role)
+	if err != nil {
+		return moderationError(ctx, err)
+	}
+
+	return rest.SuccessResponse(ctx, http.StatusOK, "user role updated", nil)
+
+}
+
+func moderationError(ctx *fiber.Ctx, err error) error {
+
+	if errors.Is(err, domain.ErrorUserNotFound) {
+		return rest.NotFoundError(ctx, err)
+	}
+	if errors.Is(err, domain.ErrorInvalidRole) || errors.Is(err, domain.ErrorModerateSelf) {
+		return rest.BadRequest(ctx, err.Error())
+	}
+
+	return rest.InternalError(ctx, err)
+
+}
+
+// tooManyAttempts responds 429 with the remaining lockout as Retry-After.
+func tooManyAttempts(ctx *fiber.Ctx, err error) error {
+
+	var tooMany domain.TooManyAttemptsError
+	if errors.As(err, &tooMany) {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+	}
+
+	return rest.ErrorMessage(ctx, http.StatusTooManyRequests, err)
+
+}
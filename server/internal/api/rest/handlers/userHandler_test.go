@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/internal/dto"
+	"ecommerce/internal/helper"
+	"ecommerce/internal/repository"
+	"ecommerce/internal/service"
+	"ecommerce/pkg/money"
+	"ecommerce/pkg/payment"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sensitiveKeys must never show up in a response body, whatever the nesting.
+var sensitiveKeys = []string{"password", "code", "expiry", "totp_secret", "client_secret", "payment_id"}
+
+func (r *stubUserRepo) FindUserById(id uint) (domain.User, error) {
+	if id != r.user.ID {
+		return domain.User{}, domain.ErrorUserNotFound
+	}
+	return r.user, nil
+}
+
+func (r *stubUserRepo) FindAddresses(uId uint) ([]domain.Address, error) {
+	return r.user.Addresses, nil
+}
+
+func (r *stubUserRepo) FindOrders(uId uint) ([]domain.Order, error) {
+	return r.orders, nil
+}
+
+func (r *stubUserRepo) FindUser(email string) (domain.User, error) {
+	if email != r.user.Email {
+		return domain.User{}, domain.ErrorUserNotFound
+	}
+	return r.user, nil
+}
+
+// stubThrottleRepo counts attempts in memory with the real policies.
+type stubThrottleRepo struct {
+	throttles map[string]*domain.AuthThrottle
+}
+
+func (r *stubThrottleRepo) FindThrottles(keys []string) ([]*domain.AuthThrottle, error) {
+	var found []*domain.AuthThrottle
+	for _, key := range keys {
+		if t, ok := r.throttles[key]; ok {
+			found = append(found, t)
+		}
+	}
+	return found, nil
+}
+
+func (r *stubThrottleRepo) RegisterAttempt(key string, policy domain.ThrottlePolicy) (*domain.AuthThrottle, error) {
+	if r.throttles == nil {
+		r.throttles = map[string]*domain.AuthThrottle{}
+	}
+	t, ok := r.throttles[key]
+	if !ok {
+		t = &domain.AuthThrottle{Key: key}
+		r.throttles[key] = t
+	}
+	policy.Register(t, time.Now())
+	return t, nil
+}
+
+func (r *stubThrottleRepo) ResetThrottle(key string) error {
+	delete(r.throttles, key)
+	return nil
+}
+
+type stubSessionRepo struct {
+	repository.SessionRepository
+}
+
+func (r *stubSessionRepo) FindSession(id string) (*domain.Session, error) {
+	return &domain.Session{}, nil
+}
+
+func (r *stubProductRepo) GetProductById(id uint) (*domain.Product, error) {
+	return &domain.Product{ID: id, Name: "Mug", Price: money.New(500, "USD"), UserId: 2, Stock: 4}, nil
+}
+
+func (r *stubProductRepo) ReservedStock(productIds []uint, exceptUserId uint) (map[uint]uint, error) {
+	return map[uint]uint{}, nil
+}
+
+func (r *stubUserRepo) FindCartItems(uId uint) ([]domain.Cart, error) {
+	return append([]domain.Cart(nil), r.cart...), nil
+}
+
+func (r *stubUserRepo) FindCartItem(uId, pId uint) (domain.Cart, error) {
+	for _, c := range r.cart {
+		if c.ProductId == pId {
+			return c, nil
+		}
+	}
+	return domain.Cart{}, domain.ErrorUserProductCartNotFound
+}
+
+func (r *stubUserRepo) UpdateCart(c domain.Cart) error {
+	for i := range r.cart {
+		if r.cart[i].ID == c.ID {
+			r.cart[i] = c
+		}
+	}
+	return nil
+}
+
+func (r *stubUserRepo) FindAddressById(id, uId uint) (domain.Address, error) {
+	for _, a := range r.user.Addresses {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return domain.Address{}, domain.ErrorAddressNotFound
+}
+
+func (r *stubUserRepo) CreateAddress(e domain.Address) (*domain.Address, error) {
+	e.ID = uint(len(r.user.Addresses) + 1)
+	r.user.Addresses = append(r.user.Addresses, e)
+	return &e, nil
+}
+
+func (r *stubUserRepo) UpdateAddress(e domain.Address) (*domain.Address, error) {
+	return &e, nil
+}
+
+func (r *stubUserRepo) ClearDefaultAddress(uId uint, shipping, billing bool) error {
+	return nil
+}
+
+func (r *stubProductRepo) FindProductsByIds(ids []uint) ([]*domain.Product, error) {
+	var prods []*domain.Product
+	for _, id := range ids {
+		prod, _ := r.GetProductById(id)
+		prods = append(prods, prod)
+	}
+	return prods, nil
+}
+
+func (r *stubTransactionRepo) FindInitialPayment(u uint) (*domain.Payment, error) {
+	return nil, domain.ErrorUserInitialPaymentNotFound
+}
+
+func (r *stubTransactionRepo) FindOrders(sellerId uint, filter dto.SellerOrderFilter) ([]dto.SellerOrderDetails, int64, error) {
+	return r.sellerOrders, int64(len(r.sellerOrders)), nil
+}
+
+func (r *stubTransactionRepo) FindOrderById(orderItemId, sellerId uint) (dto.SellerOrderDetails, error) {
+	for _, o := range r.sellerOrders {
+		if o.OrderItemId == orderItemId {
+			return o, nil
+		}
+	}
+	return dto.SellerOrderDetails{}, domain.ErrorOrderNotFound
+}
+
+// newResponseFixture serves every endpoint that returns user, order or cart
+// data, for a user whose records have every secret field filled in, so a leak
+// shows up in the body. The user sells the items of their own order so the
+// seller endpoints see it too.
+func newResponseFixture(t *testing.T) *fiber.App {
+
+	t.Helper()
+
+	user := domain.User{
+		ID:               7,
+		Email:            "buyer@example.com",
+		Phone:            "+15550100",
+		Password:         "$2a$10$hash",
+		Code:             123456,
+		Expiry:           time.Now().Add(time.Hour),
+		TotpSecret:       "enc:v1:secret",
+		TwoFactorEnabled: true,
+		UserType:         domain.SELLER,
+		Addresses:        []domain.Address{{ID: 1, AddressLine1: "1 Main St", City: "Springfield", Country: "US", UserId: 7}},
+	}
+
+	pc := payment.NewFakePaymentClient(testWebhookSecret)
+	intent, err := pc.CreatePayment(money.New(1000, "USD"), user.ID, "ORDER1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.GetPaymentStatus(intent.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	order := domain.Order{
+		ID:            1,
+		UserId:        7,
+		Status:        domain.OrderStatusPaid,
+		Amount:        money.New(1000, "USD"),
+		TransactionId: "txn_fixture",
+		OrderRef:      "ORDER1",
+		PaymentId:     intent.ID,
+		Items:         []domain.OrderItem{{ID: 1, OrderId: 1, ProductId: 3, SellerId: 7, Price: money.New(500, "USD"), Qty: 2, RefundedQty: 1}},
+		Refunds:       []domain.Refund{{ID: 1, PaymentId: 1, OrderId: 1, OrderItemId: 1, ProductId: 3, Qty: 1, Amount: money.New(500, "USD"), Status: domain.RefundStatusSucceeded, GatewayRefundId: "re_fixture", IdempotencyKey: "refund_fixture"}},
+	}
+
+	userRepo := &stubUserRepo{
+		user:   user,
+		orders: []domain.Order{order},
+		cart:   []domain.Cart{{ID: 1, UserId: 7, ProductId: 3, Name: "Mug", SellerId: 2, Price: money.New(450, "USD"), Qty: 1}},
+	}
+	pRepo := &stubProductRepo{}
+	tRepo := &stubTransactionRepo{
+		payment: domain.Payment{ID: 1, UserId: 7, PaymentId: intent.ID, OrderId: "ORDER1", Amount: order.Amount, ClientSecret: intent.ClientSecret, Status: domain.PaymentStatusPartiallyRefunded},
+		order:   order,
+		sellerOrders: []dto.SellerOrderDetails{{
+			OrderRefNumber: "ORDER1", OrderStatus: string(domain.OrderStatusPaid), OrderItemId: 1, ProductId: 3, Name: "Mug",
+			Price: money.New(500, "USD"), Qty: 2, CustomerName: "Buyer", CustomerEmail: user.Email, CustomerAddress: "1 Main St",
+		}},
+	}
+
+	users := UserHandler{svc: service.UserService{
+		Repo:  userRepo,
+		PRepo: pRepo,
+		TRepo: tRepo,
+		Uow:   stubUnitOfWork{repos: repository.Repositories{User: userRepo, Product: pRepo, Transaction: tRepo}},
+		Pc:    pc,
+	}}
+	transactions := TransactionHandler{
+		svc:           service.TransactionService{Repo: tRepo, PRepo: pRepo, Pc: pc},
+		paymentClient: pc,
+	}
+	catalog := CatalogHandler{prodSvc: service.ProductService{Repo: pRepo}}
+
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("user", user)
+		return ctx.Next()
+	})
+	app.Get("/users/profile", users.getProfile)
+	app.Get("/users/addresses", users.getAddresses)
+	app.Post("/users/addresses", users.addAddress)
+	app.Patch("/users/addresses/:id", users.updateAddress)
+	app.Get("/users/cart", users.getCart)
+	app.Post("/users/cart", users.addToCart)
+	app.Post("/users/cart/acknowledge", users.acknowledgeCart)
+	app.Get("/users/order", users.getOrders)
+	app.Get("/users/order/:id", users.getOrder)
+	app.Get("/products/:id", catalog.GetProduct)
+	app.Get("/transactions/seller/orders", transactions.GetOrders)
+	app.Get("/transactions/seller/orders/:id", transactions.GetOrderById)
+	app.Patch("/transactions/seller/orders/:ref/status", transactions.UpdateSellerOrderStatus)
+	app.Post("/transactions/admin/orders/:ref/refunds", transactions.RefundAdminOrder)
+
+	return app
+}
+
+// findKeys returns the object keys of v, at any depth, that are in keys.
+func findKeys(v interface{}, keys []string) []string {
+
+	var found []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			for _, key := range keys {
+				if k == key {
+					found = append(found, k)
+				}
+			}
+			found = append(found, findKeys(child, keys)...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			found = append(found, findKeys(child, keys)...)
+		}
+	}
+	return found
+}
+
+func TestResponsesOmitSensitiveFields(t *testing.T) {
+
+	app := newResponseFixture(t)
+
+	// in order, the status change and refund move the fixture order along
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/users/profile", ""},
+		{http.MethodGet, "/users/addresses", ""},
+		{http.MethodPost, "/users/addresses", `{"address_line1":"2 Side St","city":"Springfield","country":"US"}`},
+		{http.MethodPatch, "/users/addresses/1", `{"city":"Shelbyville"}`},
+		{http.MethodGet, "/users/cart", ""},
+		{http.MethodPost, "/users/cart", `{"product_id":3,"qty":2}`},
+		{http.MethodPost, "/users/cart/acknowledge", ""},
+		{http.MethodGet, "/users/order", ""},
+		{http.MethodGet, "/users/order/ORDER1", ""},
+		{http.MethodGet, "/products/3", ""},
+		{http.MethodGet, "/transactions/seller/orders", ""},
+		{http.MethodGet, "/transactions/seller/orders/1", ""},
+		{http.MethodPatch, "/transactions/seller/orders/ORDER1/status", `{"status":"processing"}`},
+		{http.MethodPost, "/transactions/admin/orders/ORDER1/refunds", `{"reason":"damaged"}`},
+	}
+
+	for _, r := range requests {
+		t.Run(r.method+" "+r.path, func(t *testing.T) {
+
+			res, err := app.Test(jsonRequest(r.method, r.path, r.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+				t.Fatalf("status = %d, want success", res.StatusCode)
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["data"] == nil {
+				t.Fatal("response has no data")
+			}
+
+			if leaked := findKeys(body, sensitiveKeys); len(leaked) > 0 {
+				t.Errorf("response exposes %v", leaked)
+			}
+		})
+	}
+}
+
+func TestForgotPasswordThrottled(t *testing.T) {
+
+	users := UserHandler{svc: service.UserService{Repo: &stubUserRepo{}, ThRepo: &stubThrottleRepo{}}}
+
+	app := fiber.New()
+	app.Post("/password/forgot", users.forgotPassword)
+
+	// unknown emails are counted the same, so a lockout reveals nothing
+	for i := 1; i <= 4; i++ {
+		res, err := app.Test(jsonRequest(http.MethodPost, "/password/forgot", `{"email":"victim@example.com"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := http.StatusOK
+		if i == 4 {
+			want = http.StatusTooManyRequests
+		}
+		if res.StatusCode != want {
+			t.Fatalf("request %d: status = %d, want %d", i, res.StatusCode, want)
+		}
+		if i == 4 && res.Header.Get(fiber.HeaderRetryAfter) == "" {
+			t.Error("throttled response has no Retry-After")
+		}
+	}
+}
+
+// A stolen access token must not allow unlimited guesses of the password.
+func TestChangePasswordThrottled(t *testing.T) {
+
+	hash, err := helper.Auth{}.CreateHashedPassword("current-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := domain.User{ID: 7, Email: "buyer@example.com", Password: hash}
+
+	users := UserHandler{svc: service.UserService{Repo: &stubUserRepo{user: user}, SRepo: &stubSessionRepo{}, ThRepo: &stubThrottleRepo{}}}
+
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("user", user)
+		return ctx.Next()
+	})
+	app.Patch("/users/password", users.changePassword)
+
+	// bcrypt is slow, more so under the race detector; no request timeout
+
+	for i := 1; i <= domain.LoginAccountThrottle.MaxAttempts; i++ {
+		res, err := app.Test(jsonRequest(http.MethodPatch, "/users/password", `{"current_password":"guess","new_password":"new-secret"}`), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("guess %d: status = %d, want %d", i, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	// locked out, even with the right password
+	res, err := app.Test(jsonRequest(http.MethodPatch, "/users/password", `{"current_password":"current-secret","new_password":"new-secret"}`), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("after %d wrong guesses: status = %d, want %d", domain.LoginAccountThrottle.MaxAttempts, res.StatusCode, http.StatusTooManyRequests)
+	}
+}
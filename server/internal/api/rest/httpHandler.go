@@ -3,7 +3,9 @@ package rest
 import (
 	"ecommerce/config"
 	"ecommerce/internal/helper"
+	"ecommerce/pkg/notification"
 	"ecommerce/pkg/payment"
+	"ecommerce/pkg/suggest"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -15,4 +17,6 @@ type RestHandler struct {
 	Auth   helper.Auth
 	Config config.AppConfig
 	Pc     payment.PaymentClient
+	Nc     notification.NotificationClient
+	Si     *suggest.Index
 }
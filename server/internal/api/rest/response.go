@@ -26,9 +26,21 @@ func NotAuhtorizedError(ctx *fiber.Ctx, err error) error {
 	return ctx.Status(http.StatusForbidden).JSON(fiber.Map{"message": err.Error()})
 }
 
+func ConflictError(ctx *fiber.Ctx, err error) error {
+	return ctx.Status(http.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+}
+
 func SuccessResponse(ctx *fiber.Ctx, statusCode int, msg string, data interface{}) error {
 	return ctx.Status(statusCode).JSON(&fiber.Map{
 		"message": msg,
 		"data":    data,
 	})
 }
+
+func PaginatedResponse(ctx *fiber.Ctx, statusCode int, msg string, data interface{}, pagination interface{}) error {
+	return ctx.Status(statusCode).JSON(&fiber.Map{
+		"message":    msg,
+		"data":       data,
+		"pagination": pagination,
+	})
+}
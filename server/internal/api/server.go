@@ -1,13 +1,18 @@
 package api
 
 import (
+	"context"
 	"ecommerce/config"
 	"ecommerce/internal/api/rest"
 	"ecommerce/internal/api/rest/handlers"
-	"ecommerce/internal/domain"
 	"ecommerce/internal/helper"
+	"ecommerce/internal/repository"
+	"ecommerce/internal/service"
+	"ecommerce/pkg/notification"
 	"ecommerce/pkg/payment"
+	"ecommerce/pkg/suggest"
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -25,17 +30,7 @@ func StartServer(config config.AppConfig) {
 	}
 	log.Println("Database connected successfully")
 
-	// run migration
-	err = db.AutoMigrate(
-		&domain.User{},
-		&domain.BankAccount{},
-		&domain.Category{},
-		&domain.Product{},
-		&domain.Cart{},
-		&domain.Address{},
-		&domain.Order{},
-		&domain.OrderItem{},
-		&domain.Payment{})
+	err = migrate(db)
 	if err != nil {
 		log.Fatalf("error on  migration %v", err.Error())
 	}
@@ -49,9 +44,26 @@ func StartServer(config config.AppConfig) {
 
 	app.Use(c)
 
-	auth := helper.SetUpAuth(config.AppSecret)
+	var keys *helper.KeySet
+	if config.JwtConfig.KeysDir != "" {
+		keys, err = helper.LoadKeySet(config.JwtConfig.KeysDir, config.JwtConfig.SigningKeyId)
+		if err != nil {
+			log.Fatalf("error loading jwt keys %v", err)
+		}
+	}
+
+	auth := helper.SetUpAuth(config.AppSecret, keys, repository.NewSessionRepository(db))
+	auth.RequireSellerTwoFactor = config.RequireSellerTwoFactor
+	auth.Verification = repository.NewUserRepository(db)
 
-	paymentClient := payment.NewPaymentClient(config.StripeConfig.StripeSecretKey, config.StripeConfig.SuccessUrl, config.StripeConfig.CancelUrl)
+	// needs the key derived from the app secret, so it runs after migrate
+	err = encryptTotpSecrets(db, auth)
+	if err != nil {
+		log.Fatalf("error encrypting totp secrets %v", err)
+	}
+
+	paymentClient := payment.NewGateway(config)
+	notificationClient := notification.NewNotifier(config)
 
 	restHandler := &rest.RestHandler{
 		App:    app,
@@ -59,13 +71,41 @@ func StartServer(config config.AppConfig) {
 		Auth:   auth,
 		Config: config,
 		Pc:     paymentClient,
+		Nc:     notificationClient,
+		Si:     suggest.New(),
 	}
 
 	setUpRoutes(restHandler)
 
+	// background workers run for as long as the server does
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	startWorkers(ctx, restHandler)
+
 	app.Listen(config.ServerPort)
 }
 
+// startWorkers starts the background jobs of the server. They stop when ctx
+// is done.
+func startWorkers(ctx context.Context, rh *rest.RestHandler) {
+
+	transactionSvc := service.TransactionService{
+		Config: rh.Config,
+		Repo:   repository.NewTransactionRepository(rh.DB),
+		PRepo:  repository.NewProductRepository(rh.DB),
+		Pc:     rh.Pc,
+	}
+
+	productSvc := service.ProductService{
+		SRepo:  repository.NewSearchRepository(rh.DB),
+		Si:     rh.Si,
+		Config: rh.Config,
+	}
+
+	go transactionSvc.RunReservationSweeper(ctx, time.Minute)
+	go productSvc.RunSuggestionRefresher(ctx, 5*time.Minute)
+}
+
 func setUpRoutes(rh *rest.RestHandler) {
 	handlers.SetUpCatalogRoutes(rh)
 	handlers.SetupUserRoutes(rh)
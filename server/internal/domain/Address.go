@@ -1,13 +1,68 @@
 package domain
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrorAddressNotFound         = errors.New("address not found")
+	ErrorInvalidAddress          = errors.New("address line 1, city and country are required")
+	ErrorShippingAddressRequired = errors.New("please choose a shipping address")
+)
+
+// Address is an entry in a user's address book. At most one address of a
+// user is the default shipping address and at most one the default billing
+// address.
 type Address struct {
-	ID           uint   `gorm:"PrimaryKey" json:"id"`
+	ID                uint   `gorm:"PrimaryKey" json:"id"`
+	AddressLine1      string `json:"address_line1"`
+	AddressLine2      string `json:"address_line2"`
+	City              string `json:"city"`
+	PostCode          uint   `json:"post_code"`
+	Country           string `json:"country"`
+	UserId            uint   `json:"user_id" gorm:"index"`
+	IsDefaultShipping bool   `json:"is_default_shipping" gorm:"default:false"`
+	IsDefaultBilling  bool   `json:"is_default_billing" gorm:"default:false"`
+	CreatedAt         string `gorm:"default:current_timestamp"`
+	UpdatedAt         string `gorm:"default:current_timestamp"`
+}
+
+func (a Address) IsValid() bool {
+	return strings.TrimSpace(a.AddressLine1) != "" && strings.TrimSpace(a.City) != "" && strings.TrimSpace(a.Country) != ""
+}
+
+// Snapshot copies the address for an order, later edits to the address book
+// don't change where an order ships.
+func (a Address) Snapshot() AddressSnapshot {
+	return AddressSnapshot{
+		AddressLine1: a.AddressLine1,
+		AddressLine2: a.AddressLine2,
+		City:         a.City,
+		PostCode:     a.PostCode,
+		Country:      a.Country,
+	}
+}
+
+// AddressSnapshot is embedded in checkouts and orders.
+type AddressSnapshot struct {
 	AddressLine1 string `json:"address_line1"`
 	AddressLine2 string `json:"address_line2"`
 	City         string `json:"city"`
 	PostCode     uint   `json:"post_code"`
 	Country      string `json:"country"`
-	UserId       uint   `json:"user_id"`
-	CreatedAt    string `gorm:"default:current_timestamp"`
-	UpdatedAt    string `gorm:"default:current_timestamp"`
+}
+
+// String formats the address on one line, skipping empty parts.
+func (a AddressSnapshot) String() string {
+
+	parts := []string{}
+	for _, p := range []string{a.AddressLine1, a.AddressLine2, a.City, fmt.Sprint(a.PostCode), a.Country} {
+		if p != "" && p != "0" {
+			parts = append(parts, p)
+		}
+	}
+
+	return strings.Join(parts, ", ")
 }
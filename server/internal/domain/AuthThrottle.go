@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrorTooManyAttempts           = errors.New("too many attempts")
+	ErrorVerificationCodeExhausted = errors.New("too many wrong codes, please request a new verification code")
+)
+
+// ThrottlePolicy limits attempts per key. Once MaxAttempts is reached every
+// further attempt locks the key, starting at BaseLockout and doubling up to
+// MaxLockout. Attempts are forgotten after ResetAfter without any.
+type ThrottlePolicy struct {
+	MaxAttempts int
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	ResetAfter  time.Duration
+}
+
+var (
+	LoginAccountThrottle = ThrottlePolicy{MaxAttempts: 5, BaseLockout: time.Minute, MaxLockout: time.Hour, ResetAfter: 24 * time.Hour}
+	// a single address can be shared by many buyers, e.g. behind a NAT
+	LoginIpThrottle = ThrottlePolicy{MaxAttempts: 20, BaseLockout: time.Minute, MaxLockout: time.Hour, ResetAfter: 24 * time.Hour}
+	// every sent SMS counts as an attempt
+	SmsThrottle = ThrottlePolicy{MaxAttempts: 3, BaseLockout: time.Minute, MaxLockout: time.Hour, ResetAfter: time.Hour}
+	// wrong guesses of an issued verification code, locked for the lifetime
+	// of the code and reset when a new code is sent
+	VerificationCodeThrottle = ThrottlePolicy{MaxAttempts: 5, BaseLockout: 30 * time.Minute, MaxLockout: 30 * time.Minute, ResetAfter: 30 * time.Minute}
+)
+
+// AuthThrottle counts attempts for a key such as "login:ip:10.0.0.1".
+type AuthThrottle struct {
+	Key           string    `json:"key" gorm:"PrimaryKey;size:255"`
+	Attempts      int       `json:"attempts"`
+	LockedUntil   time.Time `json:"locked_until"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+}
+
+// Register records an attempt at now and extends the lockout if needed.
+func (p ThrottlePolicy) Register(t *AuthThrottle, now time.Time) {
+
+	if now.Sub(t.LastAttemptAt) > p.ResetAfter {
+		t.Attempts = 0
+	}
+
+	t.Attempts++
+	t.LastAttemptAt = now
+
+	if t.Attempts < p.MaxAttempts {
+		return
+	}
+
+	lockout := p.MaxLockout
+	if exp := t.Attempts - p.MaxAttempts; exp < 31 {
+		lockout = min(p.BaseLockout<<exp, p.MaxLockout)
+	}
+	t.LockedUntil = now.Add(lockout)
+}
+
+// TooManyAttemptsError tells the client when to retry.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e TooManyAttemptsError) Is(target error) bool {
+	return target == ErrorTooManyAttempts
+}
+
+// CheckThrottles fails with the longest running lockout among throttles.
+func CheckThrottles(now time.Time, throttles ...*AuthThrottle) error {
+
+	var wait time.Duration
+	for _, t := range throttles {
+		if t != nil && t.LockedUntil.After(now) {
+			wait = max(wait, t.LockedUntil.Sub(now))
+		}
+	}
+
+	if wait > 0 {
+		return TooManyAttemptsError{RetryAfter: wait}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"ecommerce/pkg/money"
 	"errors"
 	"time"
 )
@@ -8,17 +9,32 @@ import (
 var (
 	ErrorUserProductCartNotFound = errors.New("cart of given user and product not found")
 	ErrorCartItemNotFound        = errors.New("cart item not found")
+	ErrorCartNeedsReview         = errors.New("cart items have changed, please review and acknowledge the changes")
+)
+
+// CartIssue flags a cart line that no longer matches its product.
+type CartIssue string
+
+const (
+	CartIssuePriceChanged CartIssue = "price_changed"
+	CartIssueOutOfStock   CartIssue = "out_of_stock"
+	CartIssueUnavailable  CartIssue = "unavailable"
 )
 
 type Cart struct {
-	ID        uint      `gorm:"PrimaryKey" json:"id"`
-	UserId    uint      `json:"user_id"`
-	ProductId uint      `json:"product_id"`
-	Name      string    `json:"name"`
-	ImageUrl  string    `json:"image_url"`
-	SellerId  uint      `json:"seller_id"`
-	Price     float64   `json:"price"`
-	Qty       uint      `json:"qty"`
-	CreatedAt time.Time `json:"created_at" gorm:"default:current_timestamp"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"default:current_timestamp"`
+	ID        uint        `gorm:"PrimaryKey" json:"id"`
+	UserId    uint        `json:"user_id"`
+	ProductId uint        `json:"product_id"`
+	Name      string      `json:"name"`
+	ImageUrl  string      `json:"image_url"`
+	SellerId  uint        `json:"seller_id"`
+	Price     money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Qty       uint        `json:"qty"`
+	CreatedAt time.Time   `json:"created_at" gorm:"default:current_timestamp"`
+	UpdatedAt time.Time   `json:"updated_at" gorm:"default:current_timestamp"`
+
+	// Set when the cart is reconciled against the current products. Price
+	// keeps the price the buyer accepted until the change is acknowledged.
+	Issues       []CartIssue  `json:"issues,omitempty" gorm:"-"`
+	CurrentPrice *money.Money `json:"current_price,omitempty" gorm:"-"`
 }
@@ -6,9 +6,37 @@ import (
 )
 
 var (
-	ErrorCategoryNotFound = errors.New("category of given id not found")
+	ErrorCategoryNotFound       = errors.New("category of given id not found")
+	ErrorParentCategoryNotFound = errors.New("parent category not found")
+	ErrorCategoryCycle          = errors.New("a category can't be moved under itself or one of its subcategories")
+	ErrorCategoryNotEmpty       = errors.New("category has subcategories or products")
+	ErrorInvalidDeleteMode      = errors.New("delete mode must be reject, cascade or reparent")
 )
 
+// CategoryDeleteMode decides what happens to the subcategories and products
+// of a deleted category.
+type CategoryDeleteMode string
+
+const (
+	// CategoryDeleteReject only deletes empty categories.
+	CategoryDeleteReject CategoryDeleteMode = "reject"
+	// CategoryDeleteCascade deletes all subcategories and their products.
+	CategoryDeleteCascade CategoryDeleteMode = "cascade"
+	// CategoryDeleteReparent moves subcategories and products to the parent
+	// of the deleted category. A root category must not have products.
+	CategoryDeleteReparent CategoryDeleteMode = "reparent"
+)
+
+func (m CategoryDeleteMode) IsValid() bool {
+	switch m {
+	case CategoryDeleteReject, CategoryDeleteCascade, CategoryDeleteReparent:
+		return true
+	}
+	return false
+}
+
+// Category is a node of the category tree, root categories have ParentId 0.
+// Siblings are ordered by DisplayOrder.
 type Category struct {
 	ID           uint      `json:"id" gorm:"PrimaryKey"`
 	Name         string    `json:"name" gorm:"index;"`
@@ -18,4 +46,7 @@ type Category struct {
 	DisplayOrder int       `json:"display_order"`
 	CreatedAt    time.Time `json:"created_at" gorm:"default:current_timestamp"`
 	UpdatedAt    time.Time `json:"updated_at" gorm:"default:current_timestamp"`
+
+	// Children is only filled when the tree is built.
+	Children []*Category `json:"children,omitempty" gorm:"-"`
 }
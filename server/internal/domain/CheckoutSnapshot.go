@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"ecommerce/pkg/money"
+	"errors"
+	"time"
+)
+
+var (
+	ErrorCheckoutSnapshotNotFound = errors.New("checkout snapshot not found")
+)
+
+// CheckoutSnapshot freezes the cart at the moment a payment session is
+// created. The order for that payment is built from the snapshot, never from
+// the live cart. Snapshots are written once and never updated.
+type CheckoutSnapshot struct {
+	ID        uint                   `json:"id" gorm:"PrimaryKey"`
+	OrderRef  string                 `json:"order_ref" gorm:"index;unique;not null"`
+	UserId    uint                   `json:"user_id" gorm:"index"`
+	Amount    money.Money            `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Items     []CheckoutSnapshotItem `json:"items" gorm:"foreignKey:SnapshotId"`
+	CreatedAt time.Time              `json:"created_at" gorm:"default:current_timestamp"`
+
+	// ShippingAddress is the address chosen when the checkout started.
+	ShippingAddress AddressSnapshot `json:"shipping_address" gorm:"embedded;embeddedPrefix:shipping_"`
+}
+
+type CheckoutSnapshotItem struct {
+	ID         uint        `json:"id" gorm:"PrimaryKey"`
+	SnapshotId uint        `json:"snapshot_id" gorm:"index;not null"`
+	ProductId  uint        `json:"product_id"`
+	Name       string      `json:"name"`
+	ImageUrl   string      `json:"image_url"`
+	SellerId   uint        `json:"seller_id"`
+	Price      money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Qty        uint        `json:"qty"`
+}
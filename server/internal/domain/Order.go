@@ -1,23 +1,155 @@
 package domain
 
 import (
+	"ecommerce/pkg/money"
 	"errors"
+	"fmt"
 	"time"
 )
 
 var (
-	ErrorOrderNotFound = errors.New("order not found")
+	ErrorOrderNotFound          = errors.New("order not found")
+	ErrorInvalidOrderStatus     = errors.New("invalid order status")
+	ErrorInvalidOrderTransition = errors.New("invalid order status transition")
 )
 
+type OrderStatus string
+
+const (
+	OrderStatusPending    OrderStatus = "pending"
+	OrderStatusPaid       OrderStatus = "paid"
+	OrderStatusProcessing OrderStatus = "processing"
+	OrderStatusShipped    OrderStatus = "shipped"
+	OrderStatusDelivered  OrderStatus = "delivered"
+	OrderStatusCancelled  OrderStatus = "cancelled"
+	OrderStatusRefunded   OrderStatus = "refunded"
+	// OrderStatusPartiallyRefunded is set when some items have been refunded.
+	// The order carries on from where it was, see FulfilmentStatus.
+	OrderStatusPartiallyRefunded OrderStatus = "partially_refunded"
+)
+
+// orderTransitions lists, for every status, the statuses it may move to and
+// the actors allowed to make that move. Terminal statuses have no entry.
+// Only SYSTEM may set refunded directly; admins get there by refunding the
+// order so the money movement is always recorded. Cancelling a paid order
+// refunds it as well, see IsPaid. Partially refunded orders move on through
+// the entry of their FulfilmentStatus. Sellers may only move orders whose
+// items are all theirs, so admins fulfil orders that span sellers.
+var orderTransitions = map[OrderStatus]map[OrderStatus][]string{
+	OrderStatusPending: {
+		OrderStatusPaid:      {SYSTEM},
+		OrderStatusCancelled: {BUYER, SELLER, SYSTEM},
+	},
+	OrderStatusPaid: {
+		OrderStatusProcessing:        {SELLER, ADMIN},
+		OrderStatusCancelled:         {BUYER, SELLER},
+		OrderStatusRefunded:          {SYSTEM},
+		OrderStatusPartiallyRefunded: {SYSTEM},
+	},
+	OrderStatusProcessing: {
+		OrderStatusShipped:           {SELLER, ADMIN},
+		OrderStatusCancelled:         {SELLER},
+		OrderStatusRefunded:          {SYSTEM},
+		OrderStatusPartiallyRefunded: {SYSTEM},
+	},
+	OrderStatusShipped: {
+		OrderStatusDelivered:         {SELLER, BUYER, ADMIN},
+		OrderStatusRefunded:          {SYSTEM},
+		OrderStatusPartiallyRefunded: {SYSTEM},
+	},
+	OrderStatusDelivered: {
+		OrderStatusRefunded:          {SYSTEM},
+		OrderStatusPartiallyRefunded: {SYSTEM},
+	},
+	OrderStatusCancelled: {
+		OrderStatusRefunded: {SYSTEM},
+	},
+}
+
+func (s OrderStatus) IsValid() bool {
+	switch s {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusProcessing, OrderStatusShipped,
+		OrderStatusDelivered, OrderStatusCancelled, OrderStatusRefunded, OrderStatusPartiallyRefunded:
+		return true
+	}
+	return false
+}
+
+// IsPaid reports whether an order in status s has been paid for but not
+// shipped yet, so that cancelling it must give the money back.
+func (s OrderStatus) IsPaid() bool {
+	return s == OrderStatusPaid || s == OrderStatusProcessing
+}
+
+// CanTransitionTo reports whether the transition table allows moving to next.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	_, ok := orderTransitions[s][next]
+	return ok
+}
+
+// CanBeMovedBy reports whether actor may move an order from s to next.
+func (s OrderStatus) CanBeMovedBy(actor string, next OrderStatus) bool {
+	for _, a := range orderTransitions[s][next] {
+		if a == actor {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderTransitionError is returned when an order is asked to move to a status
+// the transition table does not allow from its current status.
+type OrderTransitionError struct {
+	From OrderStatus
+	To   OrderStatus
+}
+
+func (e OrderTransitionError) Error() string {
+	return fmt.Sprintf("order cannot move from %s to %s", e.From, e.To)
+}
+
+func (e OrderTransitionError) Is(target error) bool {
+	return target == ErrorInvalidOrderTransition
+}
+
 type Order struct {
-	ID            uint        `json:"id" gorm:"primaryKey"`
-	UserId        uint        `json:"user_id"`
-	Status        string      `json:"status"`
-	Amount        float64     `json:"amount"`
-	TransactionId string      `json:"transaction_id"`
-	OrderRef      string      `json:"order_ref" gorm:"index;unique;not null"`
-	PaymentId     string      `json:"payment_id"`
-	Items         []OrderItem `json:"items"`
-	CreatedAt     time.Time   `json:"created_at" gorm:"default:current_timestamp"`
-	UpdatedAt     time.Time   `json:"updated_at" gorm:"default:current_timestamp"`
+	ID            uint                 `json:"id" gorm:"primaryKey"`
+	UserId        uint                 `json:"user_id"`
+	Status        OrderStatus          `json:"status" gorm:"index;default:pending"`
+	Amount        money.Money          `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	TransactionId string               `json:"transaction_id"`
+	OrderRef      string               `json:"order_ref" gorm:"index;unique;not null"`
+	PaymentId     string               `json:"payment_id"`
+	Items         []OrderItem          `json:"items"`
+	History       []OrderStatusHistory `json:"history,omitempty"`
+	Refunds       []Refund             `json:"refunds,omitempty"`
+	CreatedAt     time.Time            `json:"created_at" gorm:"default:current_timestamp"`
+	UpdatedAt     time.Time            `json:"updated_at" gorm:"default:current_timestamp"`
+
+	// ShippingAddress is copied from the checkout snapshot.
+	ShippingAddress AddressSnapshot `json:"shipping_address" gorm:"embedded;embeddedPrefix:shipping_"`
+}
+
+// FulfilmentStatus returns the status that decides where the order may move
+// next. For a partially refunded order that is the status it had before it
+// was last partially refunded, so refunding a few items does not hold up the
+// rest of the order.
+func (o Order) FulfilmentStatus() OrderStatus {
+
+	if o.Status != OrderStatusPartiallyRefunded {
+		return o.Status
+	}
+
+	var latest *OrderStatusHistory
+	for i, h := range o.History {
+		if h.ToStatus == OrderStatusPartiallyRefunded && h.FromStatus != OrderStatusPartiallyRefunded &&
+			(latest == nil || h.ID > latest.ID) {
+			latest = &o.History[i]
+		}
+	}
+	if latest == nil {
+		return OrderStatusPaid
+	}
+
+	return latest.FromStatus
 }
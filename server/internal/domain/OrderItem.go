@@ -1,17 +1,21 @@
 package domain
 
-import "time"
+import (
+	"ecommerce/pkg/money"
+	"time"
+)
 
 type OrderItem struct {
-	ID        uint      `gorm:"PrimaryKey" json:"id"`
-	ProductId uint      `json:"product_id"`
-	UserId    uint      `json:"user_id"`
-	OrderId   uint      `json:"order_id"`
-	Name      string    `json:"name"`
-	ImageUrl  string    `json:"image_url"`
-	SellerId  uint      `json:"seller_id"`
-	Price     float64   `json:"price"`
-	Qty       uint      `json:"qty"`
-	CreatedAt time.Time `json:"created_at" gorm:"default:current_timestamp"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"default:current_timestamp"`
+	ID          uint        `gorm:"PrimaryKey" json:"id"`
+	ProductId   uint        `json:"product_id"`
+	UserId      uint        `json:"user_id"`
+	OrderId     uint        `json:"order_id"`
+	Name        string      `json:"name"`
+	ImageUrl    string      `json:"image_url"`
+	SellerId    uint        `json:"seller_id"`
+	Price       money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Qty         uint        `json:"qty"`
+	RefundedQty uint        `json:"refunded_qty" gorm:"default:0"`
+	CreatedAt   time.Time   `json:"created_at" gorm:"default:current_timestamp"`
+	UpdatedAt   time.Time   `json:"updated_at" gorm:"default:current_timestamp"`
 }
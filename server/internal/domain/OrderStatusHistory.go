@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+type OrderStatusHistory struct {
+	ID         uint        `json:"id" gorm:"PrimaryKey"`
+	OrderId    uint        `json:"order_id" gorm:"index;not null"`
+	FromStatus OrderStatus `json:"from_status"`
+	ToStatus   OrderStatus `json:"to_status"`
+	ActorId    uint        `json:"actor_id"`
+	ActorRole  string      `json:"actor_role"`
+	Note       string      `json:"note"`
+	CreatedAt  time.Time   `json:"created_at" gorm:"default:current_timestamp"`
+}
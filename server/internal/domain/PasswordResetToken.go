@@ -0,0 +1,24 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// PasswordResetTokenTTL is how long a password reset link stays valid.
+const PasswordResetTokenTTL = 30 * time.Minute
+
+var (
+	ErrorInvalidResetToken = errors.New("invalid or expired password reset token")
+)
+
+// PasswordResetToken is a single use token sent to the user to set a new
+// password. Only its hash is stored.
+type PasswordResetToken struct {
+	ID        uint       `json:"id" gorm:"PrimaryKey"`
+	UserId    uint       `json:"user_id" gorm:"index;not null"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"default:current_timestamp"`
+}
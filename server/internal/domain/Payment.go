@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"ecommerce/pkg/money"
 	"errors"
 	"time"
 )
@@ -14,11 +15,11 @@ type Payment struct {
 	ID            uint          `json:"id" gorm:"primaryKey"`
 	UserId        uint          `json:"user_id"`
 	CaptureMethod string        `json:"capture_method"`
-	Amount        float64       `json:"amount"`
-	CustomerId    string        `json:"customer_id"` // stripe id
-	PaymentId     string        `json:"payment_id"`  // paymnent id
+	Amount        money.Money   `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	CustomerId    string        `json:"customer_id"`             // stripe id
+	PaymentId     string        `json:"payment_id" gorm:"index"` // paymnent id
 	OrderId       string        `json:"order_id"`
-	Status        PaymentStatus `json:"status" gorm:"default:initial"` // initial, success, failed, pending
+	Status        PaymentStatus `json:"status" gorm:"default:initial"` // initial, success, failed, pending, partially_refunded, refunded
 	Response      string        `json:"response"`                      // response from payment gateway
 	ClientSecret  string        `json:"client"`
 	CreatedAt     time.Time     `json:"created_at" gorm:"default:current_timestamp"`
@@ -28,8 +29,36 @@ type Payment struct {
 type PaymentStatus string
 
 const (
-	PaymentStatusInitial PaymentStatus = "initial"
-	PaymensStatusSuccess PaymentStatus = "success"
-	PaymentStatusFailed  PaymentStatus = "failed"
-	PaymentStatusPending PaymentStatus = "pending"
+	PaymentStatusInitial  PaymentStatus = "initial"
+	PaymensStatusSuccess  PaymentStatus = "success"
+	PaymentStatusFailed   PaymentStatus = "failed"
+	PaymentStatusPending  PaymentStatus = "pending"
+	PaymentStatusRefunded PaymentStatus = "refunded"
+	// PaymentStatusPartiallyRefunded is set while some, but not all, of the
+	// order items have been refunded.
+	PaymentStatusPartiallyRefunded PaymentStatus = "partially_refunded"
 )
+
+// paymentTransitions lists the statuses a payment may move to. A failed
+// payment can still succeed because the gateway lets the buyer retry the same
+// intent.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusInitial:           {PaymentStatusPending, PaymensStatusSuccess, PaymentStatusFailed},
+	PaymentStatusPending:           {PaymensStatusSuccess, PaymentStatusFailed},
+	PaymentStatusFailed:            {PaymensStatusSuccess},
+	PaymensStatusSuccess:           {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+	PaymentStatusPartiallyRefunded: {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+}
+
+// PaymentStatusesBefore returns the statuses a payment may be in to move to next.
+func PaymentStatusesBefore(next PaymentStatus) []PaymentStatus {
+	var statuses []PaymentStatus
+	for from, targets := range paymentTransitions {
+		for _, to := range targets {
+			if to == next {
+				statuses = append(statuses, from)
+			}
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,34 @@
+package domain
+
+import "slices"
+
+// Permission is what a route requires of the caller's role.
+type Permission string
+
+const (
+	// PermissionShop covers the buyer side: profile, cart, orders, payments.
+	PermissionShop             Permission = "shop"
+	PermissionSell             Permission = "sell"
+	PermissionManageCategories Permission = "categories:manage"
+	PermissionModerateUsers    Permission = "users:moderate"
+	PermissionRefundOrders     Permission = "orders:refund"
+	// PermissionManageOrders lets admins fulfil orders that span sellers.
+	PermissionManageOrders Permission = "orders:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	BUYER:  {PermissionShop},
+	SELLER: {PermissionShop, PermissionSell},
+	ADMIN:  {PermissionShop, PermissionManageCategories, PermissionModerateUsers, PermissionRefundOrders, PermissionManageOrders},
+}
+
+// IsValidRole reports whether role can be assigned to a user.
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants p.
+func HasPermission(role string, p Permission) bool {
+	return slices.Contains(rolePermissions[role], p)
+}
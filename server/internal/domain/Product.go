@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"ecommerce/pkg/money"
 	"errors"
 	"time"
 )
@@ -8,17 +9,18 @@ import (
 var (
 	ErrorProductNotFound   = errors.New("product of given id not found")
 	ErrorStockNotAvailable = errors.New("stock not available")
+	ErrorInvalidPrice      = errors.New("product price must be greater than zero")
 )
 
 type Product struct {
-	ID          uint      `json:"id" gorm:"PrimaryKey"`
-	Name        string    `json:"name" gorm:"index;"`
-	Description string    `json:"description"`
-	CategoryId  uint      `json:"category_id"`
-	ImageUrl    string    `json:"image_url"`
-	Price       float64   `json:"price"`
-	UserId      uint      `json:"user_id"`
-	Stock       uint      `json:"stock"`
-	CreatedAt   time.Time `json:"created_at" gorm:"default:current_timestamp"`
-	UpdatedAt   time.Time `json:"updated_at" gorm:"default:current_timestamp"`
+	ID          uint        `json:"id" gorm:"PrimaryKey"`
+	Name        string      `json:"name" gorm:"index;"`
+	Description string      `json:"description"`
+	CategoryId  uint        `json:"category_id"`
+	ImageUrl    string      `json:"image_url"`
+	Price       money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	UserId      uint        `json:"user_id"`
+	Stock       uint        `json:"stock"`
+	CreatedAt   time.Time   `json:"created_at" gorm:"default:current_timestamp"`
+	UpdatedAt   time.Time   `json:"updated_at" gorm:"default:current_timestamp"`
 }
@@ -0,0 +1,30 @@
+package domain
+
+import "errors"
+
+var (
+	ErrorEmptySearchQuery = errors.New("please provide a search query")
+)
+
+// ProductSearchHit is a product matching a search. The highlights are HTML
+// escaped text with the matched terms wrapped in <mark> tags.
+type ProductSearchHit struct {
+	Product              Product
+	Rank                 float64
+	NameHighlight        string
+	DescriptionHighlight string
+}
+
+const (
+	SuggestionProduct  = "product"
+	SuggestionCategory = "category"
+)
+
+// Suggestion is an autocomplete entry. Weight is the number of orders of
+// the product, or of all products in the category.
+type Suggestion struct {
+	Kind   string
+	ID     uint
+	Text   string
+	Weight int64
+}
@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"ecommerce/pkg/money"
+	"errors"
+	"time"
+)
+
+var (
+	ErrorNothingToRefund       = errors.New("there is nothing left to refund on this order")
+	ErrorRefundExceedsQuantity = errors.New("refund quantity exceeds the quantity left to refund")
+	ErrorOrderItemNotFound     = errors.New("order item not found")
+)
+
+type RefundStatus string
+
+const (
+	// RefundStatusPending holds the quantity while the gateway is asked to
+	// refund it, so concurrent requests cannot refund the same item twice.
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusSucceeded RefundStatus = "succeeded"
+	RefundStatusFailed    RefundStatus = "failed"
+)
+
+// Refund records the refunded quantity of one order item. A single refund
+// request produces one Refund per item and shares the gateway refund id.
+type Refund struct {
+	ID              uint        `json:"id" gorm:"PrimaryKey"`
+	PaymentId       uint        `json:"payment_id" gorm:"index;not null"`
+	OrderId         uint        `json:"order_id" gorm:"index;not null"`
+	OrderItemId     uint        `json:"order_item_id" gorm:"index;not null"`
+	ProductId       uint        `json:"product_id"`
+	Qty             uint        `json:"qty"`
+	Amount          money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Reason          string      `json:"reason"`
+	GatewayRefundId string      `json:"gateway_refund_id" gorm:"index"`
+	InitiatedBy     uint        `json:"initiated_by"`
+	InitiatorRole   string      `json:"initiator_role"`
+	CreatedAt       time.Time   `json:"created_at" gorm:"default:current_timestamp"`
+
+	// Status follows the gateway call, see RefundStatusPending. IdempotencyKey
+	// is shared by the refunds of one request and sent to the gateway with it.
+	Status         RefundStatus `json:"status" gorm:"index;default:succeeded"`
+	IdempotencyKey string       `json:"-" gorm:"index"`
+}
@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// AccessTokenTTL is the lifetime of the JWT sent with every request.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long an unused refresh token can be exchanged.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrorInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrorRefreshTokenReused  = errors.New("refresh token already used, session revoked")
+	ErrorSessionRevoked      = errors.New("session has been revoked")
+)
+
+// Session is one login of a user. Access tokens carry the session id, so
+// revoking the session ends their access before they expire.
+type Session struct {
+	ID        string     `json:"id" gorm:"PrimaryKey;size:64"`
+	UserId    uint       `json:"user_id" gorm:"index;not null"`
+	TwoFactor bool       `json:"two_factor" gorm:"default:false"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"default:current_timestamp"`
+	UpdatedAt time.Time  `json:"updated_at" gorm:"default:current_timestamp"`
+}
+
+// RefreshToken is a single use token of a session. Only its hash is stored.
+// Each refresh marks it used and issues the next one of the same session, so
+// a used token showing up again means it was stolen.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"PrimaryKey"`
+	SessionId string     `json:"session_id" gorm:"index;not null;size:64"`
+	UserId    uint       `json:"user_id" gorm:"not null"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"default:current_timestamp"`
+}
@@ -0,0 +1,29 @@
+package domain
+
+import "time"
+
+// StockReservationTTL is how long stock stays held for a payment session.
+const StockReservationTTL = 15 * time.Minute
+
+type ReservationStatus string
+
+const (
+	ReservationStatusActive    ReservationStatus = "active"
+	ReservationStatusConverted ReservationStatus = "converted"
+	ReservationStatusReleased  ReservationStatus = "released"
+)
+
+// StockReservation holds units of a product for a buyer between creating a
+// payment session and the payment outcome. Active, unexpired reservations are
+// not available to other buyers.
+type StockReservation struct {
+	ID        uint              `json:"id" gorm:"PrimaryKey"`
+	ProductId uint              `json:"product_id" gorm:"index;not null"`
+	UserId    uint              `json:"user_id"`
+	OrderRef  string            `json:"order_ref" gorm:"index;not null"`
+	Qty       uint              `json:"qty"`
+	Status    ReservationStatus `json:"status" gorm:"index;default:active"`
+	ExpiresAt time.Time         `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time         `json:"created_at" gorm:"default:current_timestamp"`
+	UpdatedAt time.Time         `json:"updated_at" gorm:"default:current_timestamp"`
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// TwoFactorChallengeTTL is how long the second login step may take.
+	TwoFactorChallengeTTL = 5 * time.Minute
+	// RecoveryCodeCount is the number of recovery codes issued at once.
+	RecoveryCodeCount = 10
+)
+
+var (
+	ErrorTwoFactorAlreadyEnabled = errors.New("two factor authentication is already enabled")
+	ErrorTwoFactorNotEnabled     = errors.New("two factor authentication is not enabled")
+	ErrorTwoFactorNotEnrolled    = errors.New("please start two factor enrollment first")
+	ErrorTwoFactorRequired       = errors.New("two factor authentication is required for sellers")
+	ErrorInvalidTwoFactorCode    = errors.New("invalid two factor code")
+)
+
+// RecoveryCode is a single use code to log in without the authenticator app.
+// Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"PrimaryKey"`
+	UserId    uint       `json:"user_id" gorm:"index;not null"`
+	CodeHash  string     `json:"-" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"default:current_timestamp"`
+}
@@ -8,10 +8,26 @@ import (
 const (
 	SELLER = "seller"
 	BUYER  = "buyer"
+	ADMIN  = "admin"
+	// SYSTEM is the actor recorded for changes made by the platform itself,
+	// such as payment confirmation.
+	SYSTEM = "system"
 )
 
+// ErrorCodeNotVerified is sent with ErrorUserNotVerified so clients can send
+// the user to the verification page.
+const ErrorCodeNotVerified = "ACCOUNT_NOT_VERIFIED"
+
 var (
-	ErrorUserNotFound = errors.New("user not found")
+	ErrorUserNotFound      = errors.New("user not found")
+	ErrorIncorrectPassword = errors.New("current password is incorrect")
+	ErrorEmailAlreadyInUse = errors.New("email is already in use")
+	ErrorInvalidEmail      = errors.New("please provide a valid email")
+	ErrorUserSuspended     = errors.New("user account is suspended")
+	ErrorInvalidRole       = errors.New("invalid user role")
+	ErrorModerateSelf      = errors.New("admins can't moderate their own account")
+	ErrorUserNotVerified   = errors.New("please verify your account first")
+	ErrorNoContactNumber   = errors.New("please add a contact number to use sms")
 )
 
 type User struct {
@@ -20,10 +36,10 @@ type User struct {
 	LastName  string    `json:"last_name"`
 	Email     string    `json:"email" gorm:"index;unique;not null"`
 	Phone     string    `json:"phone"`
-	Password  string    `json:"password"`
-	Code      int       `json:"code"`
-	Expiry    time.Time `json:"expiry"`
-	Address   Address   `json:"address"`
+	Password  string    `json:"-"`
+	Code      int       `json:"-"`
+	Expiry    time.Time `json:"-"`
+	Addresses []Address `json:"addresses"`
 	Cart      Cart      `json:"cart"`
 	Orders    []Order   `json:"orders"`
 	Payments  []Payment `json:"payments"`
@@ -31,4 +47,14 @@ type User struct {
 	UserType  string    `json:"user_type" gorm:"default:buyer"`
 	CreatedAt time.Time `json:"created_at" gorm:"default:current_timestamp"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"default:current_timestamp"`
+
+	// TotpSecret is set on enrollment, TwoFactorEnabled once it is confirmed.
+	// TotpLastStep is the time step of the last accepted code, so a code
+	// can't be used twice.
+	TotpSecret       string `json:"-"`
+	TotpLastStep     int64  `json:"-"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled" gorm:"default:false"`
+
+	// Suspended users can't log in, set by admins.
+	Suspended bool `json:"suspended" gorm:"default:false"`
 }
@@ -6,3 +6,10 @@ type CreateCategoryDTO struct {
 	ImageUrl     string `json:"image_url"`
 	DisplayOrder int    `json:"display_order"`
 }
+
+// MoveCategoryDTO moves a category under ParentId, 0 makes it a root
+// category. DisplayOrder is kept when it is not given.
+type MoveCategoryDTO struct {
+	ParentId     uint `json:"parent_id"`
+	DisplayOrder *int `json:"display_order"`
+}
@@ -0,0 +1,7 @@
+package dto
+
+// CategoryBreadcrumb is one step of the path from a root category.
+type CategoryBreadcrumb struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
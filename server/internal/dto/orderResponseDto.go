@@ -0,0 +1,114 @@
+package dto
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/pkg/money"
+	"time"
+)
+
+// OrderResponse is the buyer and admin view of an order. Payment gateway
+// references stay on the server.
+type OrderResponse struct {
+	ID              uint                   `json:"id"`
+	OrderRef        string                 `json:"order_ref"`
+	Status          domain.OrderStatus     `json:"status"`
+	Amount          money.Money            `json:"amount"`
+	ShippingAddress domain.AddressSnapshot `json:"shipping_address"`
+	Items           []OrderItemResponse    `json:"items"`
+	History         []OrderHistoryResponse `json:"history,omitempty"`
+	Refunds         []OrderRefundResponse  `json:"refunds,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+type OrderItemResponse struct {
+	ID          uint        `json:"id"`
+	OrderId     uint        `json:"order_id"`
+	ProductId   uint        `json:"product_id"`
+	Name        string      `json:"name"`
+	ImageUrl    string      `json:"image_url"`
+	SellerId    uint        `json:"seller_id"`
+	Price       money.Money `json:"price"`
+	Qty         uint        `json:"qty"`
+	RefundedQty uint        `json:"refunded_qty"`
+}
+
+type OrderHistoryResponse struct {
+	FromStatus domain.OrderStatus `json:"from_status"`
+	ToStatus   domain.OrderStatus `json:"to_status"`
+	ActorRole  string             `json:"actor_role"`
+	Note       string             `json:"note"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+type OrderRefundResponse struct {
+	ID          uint                `json:"id"`
+	OrderItemId uint                `json:"order_item_id"`
+	ProductId   uint                `json:"product_id"`
+	Qty         uint                `json:"qty"`
+	Amount      money.Money         `json:"amount"`
+	Reason      string              `json:"reason"`
+	Status      domain.RefundStatus `json:"status"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+func NewOrderResponse(o domain.Order) OrderResponse {
+
+	res := OrderResponse{
+		ID:              o.ID,
+		OrderRef:        o.OrderRef,
+		Status:          o.Status,
+		Amount:          o.Amount,
+		ShippingAddress: o.ShippingAddress,
+		Items:           make([]OrderItemResponse, 0, len(o.Items)),
+		CreatedAt:       o.CreatedAt,
+		UpdatedAt:       o.UpdatedAt,
+	}
+
+	for _, item := range o.Items {
+		res.Items = append(res.Items, OrderItemResponse{
+			ID:          item.ID,
+			OrderId:     item.OrderId,
+			ProductId:   item.ProductId,
+			Name:        item.Name,
+			ImageUrl:    item.ImageUrl,
+			SellerId:    item.SellerId,
+			Price:       item.Price,
+			Qty:         item.Qty,
+			RefundedQty: item.RefundedQty,
+		})
+	}
+
+	for _, h := range o.History {
+		res.History = append(res.History, OrderHistoryResponse{
+			FromStatus: h.FromStatus,
+			ToStatus:   h.ToStatus,
+			ActorRole:  h.ActorRole,
+			Note:       h.Note,
+			CreatedAt:  h.CreatedAt,
+		})
+	}
+
+	for _, r := range o.Refunds {
+		res.Refunds = append(res.Refunds, OrderRefundResponse{
+			ID:          r.ID,
+			OrderItemId: r.OrderItemId,
+			ProductId:   r.ProductId,
+			Qty:         r.Qty,
+			Amount:      r.Amount,
+			Reason:      r.Reason,
+			Status:      r.Status,
+			CreatedAt:   r.CreatedAt,
+		})
+	}
+
+	return res
+}
+
+func NewOrderResponses(orders []domain.Order) []OrderResponse {
+	res := make([]OrderResponse, 0, len(orders))
+	for _, o := range orders {
+		res = append(res, NewOrderResponse(o))
+	}
+	return res
+}
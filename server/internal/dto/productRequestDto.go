@@ -1,14 +1,67 @@
 package dto
 
+import "ecommerce/pkg/money"
+
 type CreateProductRequest struct {
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	CategoryId  uint    `json:"category_id"`
-	ImageUrl    string  `json:"image_url"`
-	Price       float64 `json:"price"`
-	Stock       int     `json:"stock"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	CategoryId  uint        `json:"category_id"`
+	ImageUrl    string      `json:"image_url"`
+	Price       money.Money `json:"price"`
+	Stock       int         `json:"stock"`
 }
 
 type UpdateStockRequest struct {
 	Stock int `json:"stock"`
 }
+
+const (
+	ProductSortNewest    = "newest"
+	ProductSortPriceAsc  = "price_asc"
+	ProductSortPriceDesc = "price_desc"
+	ProductSortName      = "name"
+)
+
+// ProductFilter selects a page of the product listing. Prices are decimals
+// in Currency, products in other currencies are left out when a price bound
+// is given.
+type ProductFilter struct {
+	Page       int    `query:"page"`
+	Limit      int    `query:"limit"`
+	CategoryId uint   `query:"category_id"`
+	SellerId   uint   `query:"seller_id"`
+	MinPrice   string `query:"min_price"`
+	MaxPrice   string `query:"max_price"`
+	Currency   string `query:"currency"`
+	InStock    bool   `query:"in_stock"`
+	Sort       string `query:"sort"`
+
+	// Resolved by the service before the query runs.
+	CategoryIds []uint       `query:"-"`
+	PriceFrom   *money.Money `query:"-"`
+	PriceTo     *money.Money `query:"-"`
+}
+
+// Offset returns the number of rows to skip for the requested page.
+func (f ProductFilter) Offset() int {
+	return (f.Page - 1) * f.Limit
+}
+
+func (f ProductFilter) IsValidSort() bool {
+	switch f.Sort {
+	case "", ProductSortNewest, ProductSortPriceAsc, ProductSortPriceDesc, ProductSortName:
+		return true
+	}
+	return false
+}
+
+type ProductSearchQuery struct {
+	Query string `query:"q"`
+	Page  int    `query:"page"`
+	Limit int    `query:"limit"`
+}
+
+// Offset returns the number of rows to skip for the requested page.
+func (q ProductSearchQuery) Offset() int {
+	return (q.Page - 1) * q.Limit
+}
@@ -0,0 +1,85 @@
+package dto
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/pkg/money"
+	"time"
+)
+
+type ProductResponse struct {
+	ID          uint        `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	CategoryId  uint        `json:"category_id"`
+	ImageUrl    string      `json:"image_url"`
+	Price       money.Money `json:"price"`
+	UserId      uint        `json:"user_id"`
+	Stock       uint        `json:"stock"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+func NewProductResponse(p domain.Product) ProductResponse {
+	return ProductResponse{
+		ID:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		CategoryId:  p.CategoryId,
+		ImageUrl:    p.ImageUrl,
+		Price:       p.Price,
+		UserId:      p.UserId,
+		Stock:       p.Stock,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+}
+
+func NewProductResponses(prods []*domain.Product) []ProductResponse {
+	res := make([]ProductResponse, 0, len(prods))
+	for _, p := range prods {
+		res = append(res, NewProductResponse(*p))
+	}
+	return res
+}
+
+type ProductSearchResponse struct {
+	ProductResponse
+	Rank       float64           `json:"rank"`
+	Highlights ProductHighlights `json:"highlights"`
+}
+
+// ProductHighlights hold HTML escaped text with matches wrapped in <mark>.
+type ProductHighlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func NewProductSearchResponses(hits []domain.ProductSearchHit) []ProductSearchResponse {
+	res := make([]ProductSearchResponse, 0, len(hits))
+	for _, hit := range hits {
+		res = append(res, ProductSearchResponse{
+			ProductResponse: NewProductResponse(hit.Product),
+			Rank:            hit.Rank,
+			Highlights: ProductHighlights{
+				Name:        hit.NameHighlight,
+				Description: hit.DescriptionHighlight,
+			},
+		})
+	}
+	return res
+}
+
+// SuggestionResponse is a type-ahead entry, Type is product or category.
+type SuggestionResponse struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id"`
+	Text string `json:"text"`
+}
+
+func NewSuggestionResponses(suggestions []domain.Suggestion) []SuggestionResponse {
+	res := make([]SuggestionResponse, 0, len(suggestions))
+	for _, sg := range suggestions {
+		res = append(res, SuggestionResponse{Type: sg.Kind, ID: sg.ID, Text: sg.Text})
+	}
+	return res
+}
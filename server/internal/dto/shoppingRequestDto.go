@@ -1,14 +1,16 @@
 package dto
 
+import "ecommerce/pkg/money"
+
 type CreateCartRequest struct {
 	ProductId uint `json:"product_id"`
 	Quantity  uint `json:"qty"`
 }
 
 type CreatePaymentRequest struct {
-	OrderId      string  `json:"order_id"`
-	PaymentId    string  `json:"payment_id"`
-	ClientSecret string  `json:"client"`
-	Amount       float64 `json:"amount"`
-	UserId       uint    `json:"user_id"`
+	OrderId      string      `json:"order_id"`
+	PaymentId    string      `json:"payment_id"`
+	ClientSecret string      `json:"client"`
+	Amount       money.Money `json:"amount"`
+	UserId       uint        `json:"user_id"`
 }
@@ -0,0 +1,47 @@
+package dto
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/pkg/money"
+	"time"
+)
+
+// CartItemResponse is the buyer view of a cart line. Issues and CurrentPrice
+// are set when the line no longer matches its product.
+type CartItemResponse struct {
+	ID           uint               `json:"id"`
+	ProductId    uint               `json:"product_id"`
+	Name         string             `json:"name"`
+	ImageUrl     string             `json:"image_url"`
+	SellerId     uint               `json:"seller_id"`
+	Price        money.Money        `json:"price"`
+	Qty          uint               `json:"qty"`
+	Issues       []domain.CartIssue `json:"issues,omitempty"`
+	CurrentPrice *money.Money       `json:"current_price,omitempty"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+}
+
+func NewCartItemResponse(c domain.Cart) CartItemResponse {
+	return CartItemResponse{
+		ID:           c.ID,
+		ProductId:    c.ProductId,
+		Name:         c.Name,
+		ImageUrl:     c.ImageUrl,
+		SellerId:     c.SellerId,
+		Price:        c.Price,
+		Qty:          c.Qty,
+		Issues:       c.Issues,
+		CurrentPrice: c.CurrentPrice,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+	}
+}
+
+func NewCartResponse(items []domain.Cart) []CartItemResponse {
+	res := make([]CartItemResponse, 0, len(items))
+	for _, item := range items {
+		res = append(res, NewCartItemResponse(item))
+	}
+	return res
+}
@@ -0,0 +1,30 @@
+package dto
+
+type SellerOrderFilter struct {
+	Page   int    `query:"page"`
+	Limit  int    `query:"limit"`
+	Status string `query:"status"`
+}
+
+// Offset returns the number of rows to skip for the requested page.
+func (f SellerOrderFilter) Offset() int {
+	return (f.Page - 1) * f.Limit
+}
+
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type RefundItemInput struct {
+	OrderItemId uint `json:"order_item_id"`
+	// Qty defaults to everything not yet refunded on the item.
+	Qty uint `json:"qty"`
+}
+
+// RefundRequest refunds the listed items, or every item of the order when
+// Items is empty.
+type RefundRequest struct {
+	Items  []RefundItemInput `json:"items"`
+	Reason string            `json:"reason"`
+}
@@ -1,16 +1,28 @@
 package dto
 
+import (
+	"ecommerce/pkg/money"
+	"time"
+)
+
 type SellerOrderDetails struct {
-	OrderRefNumber  uint    `json:"order_ref_number"`
-	OrderStatus     string  `json:"order_status"`
-	CreatedAt       string  `json:"created_at"`
-	OrderItemId     uint    `json:"order_item_id"`
-	ProductId       uint    `json:"product_id"`
-	Name            string  `json:"name"`
-	ImageUrl        string  `json:"image_url"`
-	Price           float64 `json:"price"`
-	Qty             uint    `json:"qty"`
-	CustomerName    string  `json:"customer_name"`
-	CustomerEmail   string  `json:"customer_email"`
-	CustomerAddress string  `json:"customer_address"`
+	OrderRefNumber  string      `json:"order_ref_number"`
+	OrderStatus     string      `json:"order_status"`
+	CreatedAt       time.Time   `json:"created_at"`
+	OrderItemId     uint        `json:"order_item_id"`
+	ProductId       uint        `json:"product_id"`
+	Name            string      `json:"name"`
+	ImageUrl        string      `json:"image_url"`
+	Price           money.Money `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	Qty             uint        `json:"qty"`
+	CustomerName    string      `json:"customer_name"`
+	CustomerEmail   string      `json:"customer_email"`
+	CustomerPhone   string      `json:"customer_phone"`
+	CustomerAddress string      `json:"customer_address"`
+}
+
+type Pagination struct {
+	Page  int   `json:"page"`
+	Limit int   `json:"limit"`
+	Total int64 `json:"total"`
 }
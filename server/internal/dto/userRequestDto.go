@@ -10,6 +10,52 @@ type UserSignUp struct {
 	Phone string `json:"phone"`
 }
 
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ForgotPasswordInput struct {
+	Email   string `json:"email"`
+	Channel string `json:"channel"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailInput struct {
+	Email           string `json:"email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorCodeInput struct {
+	Code string `json:"code"`
+}
+
+type DisableTwoFactorInput struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type SuspendUserInput struct {
+	Suspended bool `json:"suspended"`
+}
+
+type ChangeRoleInput struct {
+	Role string `json:"role"`
+}
+
 type VerificationCodeInput struct {
 	Code int `json:"code"`
 }
@@ -24,11 +70,17 @@ type SellerInput struct {
 }
 
 type AddressInput struct {
-	AddressLine1 string `json:"address_line1"`
-	AddressLine2 string `json:"address_line2"`
-	City         string `json:"city"`
-	PostCode     uint   `json:"post_code"`
-	Country      string `json:"country"`
+	AddressLine1      string `json:"address_line1"`
+	AddressLine2      string `json:"address_line2"`
+	City              string `json:"city"`
+	PostCode          uint   `json:"post_code"`
+	Country           string `json:"country"`
+	IsDefaultShipping bool   `json:"is_default_shipping"`
+	IsDefaultBilling  bool   `json:"is_default_billing"`
+}
+
+func (a AddressInput) IsEmpty() bool {
+	return a.AddressLine1 == "" && a.AddressLine2 == "" && a.City == "" && a.PostCode == 0 && a.Country == ""
 }
 
 type ProfileInput struct {
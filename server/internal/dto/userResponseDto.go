@@ -0,0 +1,105 @@
+package dto
+
+import (
+	"ecommerce/internal/domain"
+	"time"
+)
+
+// AuthTokens are issued on signup, login and refresh. The access token is
+// sent as bearer token, the refresh token exchanged at /auth/refresh.
+type AuthTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// TwoFactorChallenge is returned by login instead of tokens when the account
+// has two factor authentication enabled.
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int64  `json:"expires_in"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioning_uri"`
+}
+
+// TwoFactorConfirmation carries the recovery codes, which are only shown
+// once, and the tokens of the new two factor session.
+type TwoFactorConfirmation struct {
+	AuthTokens
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// UserResponse is the public view of a user. Domain users are never
+// serialized directly, they carry the password hash and verification code.
+type UserResponse struct {
+	ID               uint      `json:"id"`
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Email            string    `json:"email"`
+	Phone            string    `json:"phone"`
+	Verified         bool      `json:"verified"`
+	UserType         string    `json:"user_type"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type AddressResponse struct {
+	ID                uint   `json:"id"`
+	AddressLine1      string `json:"address_line1"`
+	AddressLine2      string `json:"address_line2"`
+	City              string `json:"city"`
+	PostCode          uint   `json:"post_code"`
+	Country           string `json:"country"`
+	IsDefaultShipping bool   `json:"is_default_shipping"`
+	IsDefaultBilling  bool   `json:"is_default_billing"`
+}
+
+type ProfileResponse struct {
+	UserResponse
+	Addresses []AddressResponse `json:"addresses"`
+}
+
+func NewUserResponse(u domain.User) UserResponse {
+	return UserResponse{
+		ID:               u.ID,
+		FirstName:        u.FirstName,
+		LastName:         u.LastName,
+		Email:            u.Email,
+		Phone:            u.Phone,
+		Verified:         u.Verified,
+		UserType:         u.UserType,
+		TwoFactorEnabled: u.TwoFactorEnabled,
+		CreatedAt:        u.CreatedAt,
+	}
+}
+
+func NewAddressResponse(a domain.Address) AddressResponse {
+	return AddressResponse{
+		ID:                a.ID,
+		AddressLine1:      a.AddressLine1,
+		AddressLine2:      a.AddressLine2,
+		City:              a.City,
+		PostCode:          a.PostCode,
+		Country:           a.Country,
+		IsDefaultShipping: a.IsDefaultShipping,
+		IsDefaultBilling:  a.IsDefaultBilling,
+	}
+}
+
+func NewAddressResponses(addresses []domain.Address) []AddressResponse {
+	res := make([]AddressResponse, 0, len(addresses))
+	for _, a := range addresses {
+		res = append(res, NewAddressResponse(a))
+	}
+	return res
+}
+
+func NewProfileResponse(u domain.User) ProfileResponse {
+	return ProfileResponse{
+		UserResponse: NewUserResponse(u),
+		Addresses:    NewAddressResponses(u.Addresses),
+	}
+}
@@ -1,10 +1,15 @@
 package helper
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"ecommerce/internal/domain"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,12 +18,38 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// SessionChecker tells whether the login session of an access token has
+// been revoked.
+type SessionChecker interface {
+	IsSessionActive(id string) (bool, error)
+}
+
+// VerificationChecker tells whether a user has verified their account.
+type VerificationChecker interface {
+	IsUserVerified(id uint) (bool, error)
+}
+
+// Auth signs access tokens with Keys when asymmetric keys are configured and
+// falls back to HS256 with Secret otherwise.
 type Auth struct {
-	Secret string
+	Secret   string
+	Keys     *KeySet
+	Sessions SessionChecker
+	// RequireSellerTwoFactor keeps sellers out of routes that need
+	// PermissionSell until they log in with a second factor.
+	RequireSellerTwoFactor bool
+	// Verification backs RequireVerified.
+	Verification VerificationChecker
+}
+
+// accessToken holds the claims of an access token besides the user.
+type accessToken struct {
+	sessionId string
+	twoFactor bool
 }
 
-func SetUpAuth(s string) Auth {
-	return Auth{Secret: s}
+func SetUpAuth(s string, keys *KeySet, sessions SessionChecker) Auth {
+	return Auth{Secret: s, Keys: keys, Sessions: sessions}
 }
 
 func (a Auth) CreateHashedPassword(p string) (string, error) {
@@ -37,20 +68,68 @@ func (a Auth) CreateHashedPassword(p string) (string, error) {
 	return string(hashPassword), nil
 }
 
-func (a Auth) GenerateToken(id uint, email string, role string) (string, error) {
+// GenerateToken issues a short lived access token for the given session.
+// twoFactor records whether the session was started with a second factor.
+func (a Auth) GenerateToken(id uint, email string, role string, sessionId string, twoFactor bool) (string, error) {
 
-	if id == 0 || email == "" || role == "" {
+	if id == 0 || email == "" || role == "" || sessionId == "" {
 		return "", errors.New("inputs missing to generate token")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return a.signClaims(jwt.MapClaims{
 		"user_id": id,
 		"email":   email,
 		"role":    role,
-		"exp":     time.Now().Add(time.Hour * 24 * 30).Unix(),
+		"sid":     sessionId,
+		"mfa":     twoFactor,
+		"exp":     time.Now().Add(domain.AccessTokenTTL).Unix(),
+	})
+}
+
+// GenerateChallengeToken issues the token that proves the password step of a
+// login with two factor authentication. It is no access token.
+func (a Auth) GenerateChallengeToken(id uint) (string, error) {
+
+	if id == 0 {
+		return "", errors.New("inputs missing to generate token")
+	}
+
+	return a.signClaims(jwt.MapClaims{
+		"user_id": id,
+		"purpose": "2fa",
+		"exp":     time.Now().Add(domain.TwoFactorChallengeTTL).Unix(),
 	})
+}
+
+// VerifyChallengeToken returns the user id of a valid challenge token.
+func (a Auth) VerifyChallengeToken(t string) (uint, error) {
+
+	claims, err := a.parseClaims(t)
+	if err != nil {
+		return 0, err
+	}
+
+	if purpose, _ := claims["purpose"].(string); purpose != "2fa" {
+		return 0, errors.New("invalid token")
+	}
+
+	id, ok := claims["user_id"].(float64)
+	if !ok || id < 1 {
+		return 0, errors.New("invalid token")
+	}
+
+	return uint(id), nil
+}
 
-	tokenStr, err := token.SignedString([]byte(a.Secret))
+func (a Auth) signClaims(claims jwt.MapClaims) (string, error) {
+
+	var tokenStr string
+	var err error
+	if a.Keys != nil {
+		tokenStr, err = a.Keys.sign(claims)
+	} else {
+		tokenStr, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(a.Secret))
+	}
 	if err != nil {
 		fmt.Printf("Error signing token %v", err)
 		return "", errors.New("unable to sign the token")
@@ -59,6 +138,27 @@ func (a Auth) GenerateToken(id uint, email string, role string) (string, error)
 	return tokenStr, nil
 }
 
+// GenerateOpaqueToken returns a random url safe token, used for session ids
+// and refresh tokens.
+func (a Auth) GenerateOpaqueToken() (string, error) {
+
+	buffer := make([]byte, 32)
+	_, err := rand.Read(buffer)
+	if err != nil {
+		fmt.Printf("Error generating token %v", err)
+		return "", errors.New("unable to generate token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// HashToken is how opaque tokens are stored, so a leaked table can't be
+// replayed.
+func (a Auth) HashToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
+
 func (a Auth) VerifyPassword(pP string, hP string) error {
 
 	err := bcrypt.CompareHashAndPassword([]byte(hP), []byte(pP))
@@ -70,19 +170,64 @@ func (a Auth) VerifyPassword(pP string, hP string) error {
 }
 
 func (a Auth) VerifyToken(t string) (domain.User, error) {
+	user, _, err := a.verifyToken(t)
+	return user, err
+}
+
+// verifyToken validates the access token and returns its user and claims.
+func (a Auth) verifyToken(t string) (domain.User, accessToken, error) {
+
+	claims, err := a.parseClaims(t)
+	if err != nil {
+		return domain.User{}, accessToken{}, err
+	}
+
+	// tokens issued before sessions existed and challenge tokens carry no
+	// session id
+	sessionId, ok := claims["sid"].(string)
+	if !ok || sessionId == "" {
+		return domain.User{}, accessToken{}, errors.New("invalid token")
+	}
+
+	if a.Sessions != nil {
+		active, err := a.Sessions.IsSessionActive(sessionId)
+		if err != nil {
+			return domain.User{}, accessToken{}, err
+		}
+		if !active {
+			return domain.User{}, accessToken{}, domain.ErrorSessionRevoked
+		}
+	}
+
+	user := domain.User{}
+	user.ID = uint(claims["user_id"].(float64))
+	user.Email = claims["email"].(string)
+	user.UserType = claims["role"].(string)
+
+	twoFactor, _ := claims["mfa"].(bool)
+
+	return user, accessToken{sessionId: sessionId, twoFactor: twoFactor}, nil
+}
+
+// parseClaims checks the signature and expiry of a bearer token.
+func (a Auth) parseClaims(t string) (jwt.MapClaims, error) {
 
 	// Bearer value
 	if len(t) < 1 {
-		return domain.User{}, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	tokenStr := strings.TrimPrefix(t, "Bearer ")
 	tokenStr = strings.TrimSpace(tokenStr)
 	if len(tokenStr) < 1 {
-		return domain.User{}, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		// with asymmetric keys configured HMAC tokens are no longer accepted
+		if a.Keys != nil {
+			return a.Keys.verificationKey(t)
+		}
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			fmt.Println("signing method error")
 			return nil, fmt.Errorf("unknown signing method %v", t.Header)
@@ -90,63 +235,87 @@ func (a Auth) VerifyToken(t string) (domain.User, error) {
 		return []byte(a.Secret), nil
 	})
 	if err != nil {
-		return domain.User{}, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 
 		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			return domain.User{}, errors.New("token is expired")
+			return nil, errors.New("token is expired")
 		}
 
-		user := domain.User{}
-		user.ID = uint(claims["user_id"].(float64))
-		user.Email = claims["email"].(string)
-		user.UserType = claims["role"].(string)
-
-		return user, nil
+		return claims, nil
 	}
 
-	return domain.User{}, errors.New("token validation failed")
+	return nil, errors.New("token validation failed")
 }
 
-func (a Auth) Authorize(ctx *fiber.Ctx) error {
+// Authorize returns a middleware that lets requests through whose access
+// token is valid and whose role grants every one of perms. Without perms any
+// logged in user passes.
+func (a Auth) Authorize(perms ...domain.Permission) fiber.Handler {
+
+	return func(ctx *fiber.Ctx) error {
+
+		authHeader := ctx.Get("Authorization")
+		user, access, err := a.verifyToken(authHeader)
+		if err != nil || user.ID < 1 {
+			fmt.Println(err)
+			reason := "invalid token"
+			if err != nil {
+				reason = err.Error()
+			}
+			return ctx.Status(http.StatusUnauthorized).JSON(&fiber.Map{
+				"message": "authorization failed",
+				"reason":  reason,
+			})
+		}
+
+		for _, p := range perms {
+			if !domain.HasPermission(user.UserType, p) {
+				return ctx.Status(http.StatusForbidden).JSON(&fiber.Map{
+					"message": "authentication failed",
+					"reason":  fmt.Sprintf("%s permission required", p),
+				})
+			}
+		}
+
+		if a.RequireSellerTwoFactor && slices.Contains(perms, domain.PermissionSell) && !access.twoFactor {
+			return ctx.Status(http.StatusForbidden).JSON(&fiber.Map{
+				"message": "authentication failed",
+				"reason":  "sellers must enable two factor authentication and log in again",
+			})
+		}
 
-	authHeader := ctx.Get("Authorization")
-	user, err := a.VerifyToken(authHeader)
-	if err == nil && user.ID > 0 {
 		ctx.Locals("user", user)
+		ctx.Locals("session_id", access.sessionId)
 		return ctx.Next()
-	} else {
-		fmt.Println(err)
-		return ctx.Status(http.StatusUnauthorized).JSON(&fiber.Map{
-			"message": "authorization failed",
-			"reason":  err.Error(),
-		})
 	}
 
 }
 
-func (a Auth) AuthorizeSeller(ctx *fiber.Ctx) error {
+// RequireVerified must follow Authorize. It stops users who haven't verified
+// their account with ErrorCodeNotVerified.
+func (a Auth) RequireVerified(ctx *fiber.Ctx) error {
+
+	user := a.GetCurrentUser(ctx)
 
-	authHeader := ctx.Get("Authorization")
-	user, err := a.VerifyToken(authHeader)
+	verified, err := a.Verification.IsUserVerified(user.ID)
 	if err != nil {
-		fmt.Println(err)
-		return ctx.Status(http.StatusUnauthorized).JSON(&fiber.Map{
-			"message": "authorization failed",
-			"reason":  err.Error(),
+		return ctx.Status(http.StatusInternalServerError).JSON(&fiber.Map{
+			"message": err.Error(),
 		})
-	} else if user.ID > 0 && user.UserType == domain.SELLER {
-		ctx.Locals("user", user)
-		return ctx.Next()
-	} else {
+	}
+
+	if !verified {
 		return ctx.Status(http.StatusForbidden).JSON(&fiber.Map{
-			"message": "authentication failed",
-			"reason":  "please join in as a seller",
+			"message": domain.ErrorUserNotVerified.Error(),
+			"code":    domain.ErrorCodeNotVerified,
 		})
 	}
 
+	return ctx.Next()
+
 }
 
 func (a Auth) GetCurrentUser(ctx *fiber.Ctx) domain.User {
@@ -156,6 +325,29 @@ func (a Auth) GetCurrentUser(ctx *fiber.Ctx) domain.User {
 
 }
 
+// JWKS publishes the verification keys so other services can check our
+// tokens without holding a signing secret.
+func (a Auth) JWKS(ctx *fiber.Ctx) error {
+
+	if a.Keys == nil {
+		return ctx.Status(http.StatusNotFound).JSON(&fiber.Map{
+			"message": "asymmetric token signing is not configured",
+		})
+	}
+
+	ctx.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return ctx.Status(http.StatusOK).JSON(a.Keys.JWKS())
+
+}
+
+// GetCurrentSessionId returns the session of the authorized request.
+func (a Auth) GetCurrentSessionId(ctx *fiber.Ctx) string {
+
+	sessionId, _ := ctx.Locals("session_id").(string)
+	return sessionId
+
+}
+
 func (a Auth) GenerateVerificationCode() (int, error) {
 	return RandomNumber(6)
 }
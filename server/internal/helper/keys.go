@@ -0,0 +1,173 @@
+package helper
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type jwtKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+// KeySet holds the asymmetric keys access tokens are signed and verified
+// with. Every key is a PEM file in one directory named after its key id, e.g.
+// 2024-06.pem. One private key signs new tokens. To rotate, add the new key,
+// switch the signing key id and keep the old key (private or just its public
+// half as <kid>.pub.pem) until the tokens it signed have expired.
+type KeySet struct {
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// LoadKeySet reads the RSA (RS256) and Ed25519 (EdDSA) keys in dir.
+func LoadKeySet(dir string, signingKid string) (*KeySet, error) {
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{keys: map[string]*jwtKey{}}
+
+	for _, file := range files {
+		kid := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".pem"), ".pub")
+
+		key, err := readKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", file, err)
+		}
+		key.kid = kid
+
+		// a private key wins over the public half of the same kid
+		if existing, ok := ks.keys[kid]; ok && existing.private != nil {
+			continue
+		}
+		ks.keys[kid] = key
+	}
+
+	signing, ok := ks.keys[signingKid]
+	if !ok || signing.private == nil {
+		return nil, fmt.Errorf("no private jwt key with id %q in %s", signingKid, dir)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+func readKey(file string) (*jwtKey, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &jwtKey{method: jwt.SigningMethodRS256, public: &k.PublicKey, private: k}, nil
+	case *rsa.PublicKey:
+		return &jwtKey{method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PrivateKey:
+		return &jwtKey{method: jwt.SigningMethodEdDSA, public: k.Public(), private: k}, nil
+	case ed25519.PublicKey:
+		return &jwtKey{method: jwt.SigningMethodEdDSA, public: k}, nil
+	}
+
+	return nil, errors.New("only RSA and Ed25519 keys are supported")
+}
+
+// sign signs the claims with the current signing key and sets its kid header.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.kid
+
+	return token.SignedString(ks.signing.private)
+}
+
+// verificationKey returns the public key for the token's kid, provided the
+// token is signed with that key's algorithm.
+func (ks *KeySet) verificationKey(t *jwt.Token) (interface{}, error) {
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+	}
+
+	return key.public, nil
+}
+
+// JWKS returns the public keys as a JSON Web Key Set.
+func (ks *KeySet) JWKS() map[string]interface{} {
+
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	keys := make([]map[string]string, 0, len(kids))
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		jwk := map[string]string{
+			"kid": kid,
+			"alg": key.method.Alg(),
+			"use": "sig",
+		}
+
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
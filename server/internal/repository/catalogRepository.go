@@ -6,6 +6,7 @@ import (
 	"log"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CatalogRepository interface {
@@ -14,6 +15,14 @@ type CatalogRepository interface {
 	FindCategoryById(id uint) (*domain.Category, error)
 	EditCategory(e *domain.Category) (*domain.Category, error)
 	DeleteCategory(id uint) error
+
+	// Tree maintenance
+	LockCategories() ([]*domain.Category, error)
+	ReparentCategories(parentId uint, newParentId uint) error
+	DeleteCategories(ids []uint) error
+	CountCategoryProducts(ids []uint) (int64, error)
+	MoveCategoryProducts(ids []uint, categoryId uint) error
+	DeleteCategoryProducts(ids []uint) ([]uint, error)
 }
 
 type catalogRepository struct {
@@ -42,7 +51,7 @@ func (r catalogRepository) CreateCategory(e *domain.Category) error {
 func (r catalogRepository) FindCategories() ([]*domain.Category, error) {
 
 	var categories []*domain.Category
-	err := r.db.Find(&categories).Error
+	err := r.db.Order("display_order, id").Find(&categories).Error
 	if err != nil {
 		return nil, err
 	}
@@ -50,6 +59,90 @@ func (r catalogRepository) FindCategories() ([]*domain.Category, error) {
 	return categories, nil
 }
 
+// LockCategories implements CatalogRepository. It loads every category and
+// holds them until the transaction ends, so concurrent moves can't build a
+// cycle between them.
+func (r catalogRepository) LockCategories() ([]*domain.Category, error) {
+
+	var categories []*domain.Category
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).Order("display_order, id").Find(&categories).Error
+	if err != nil {
+		log.Printf("Lock categories failed %v.\n", err)
+		return nil, errors.New("failed to load categories")
+	}
+
+	return categories, nil
+}
+
+// ReparentCategories implements CatalogRepository.
+func (r catalogRepository) ReparentCategories(parentId uint, newParentId uint) error {
+
+	err := r.db.Model(&domain.Category{}).Where("parent_id=?", parentId).Update("parent_id", newParentId).Error
+	if err != nil {
+		log.Printf("Reparent categories failed %v.\n", err)
+		return errors.New("failed to move subcategories")
+	}
+
+	return nil
+}
+
+// DeleteCategories implements CatalogRepository.
+func (r catalogRepository) DeleteCategories(ids []uint) error {
+
+	err := r.db.Where("id IN ?", ids).Delete(&domain.Category{}).Error
+	if err != nil {
+		log.Printf("Delete categories failed %v.\n", err)
+		return errors.New("failed to delete categories")
+	}
+
+	return nil
+}
+
+// CountCategoryProducts implements CatalogRepository.
+func (r catalogRepository) CountCategoryProducts(ids []uint) (int64, error) {
+
+	var count int64
+	err := r.db.Model(&domain.Product{}).Where("category_id IN ?", ids).Count(&count).Error
+	if err != nil {
+		log.Printf("Count category products failed %v.\n", err)
+		return 0, errors.New("failed to count category products")
+	}
+
+	return count, nil
+}
+
+// MoveCategoryProducts implements CatalogRepository.
+func (r catalogRepository) MoveCategoryProducts(ids []uint, categoryId uint) error {
+
+	err := r.db.Model(&domain.Product{}).Where("category_id IN ?", ids).Update("category_id", categoryId).Error
+	if err != nil {
+		log.Printf("Move category products failed %v.\n", err)
+		return errors.New("failed to move category products")
+	}
+
+	return nil
+}
+
+// DeleteCategoryProducts implements CatalogRepository.
+// It returns the ids of the deleted products.
+func (r catalogRepository) DeleteCategoryProducts(ids []uint) ([]uint, error) {
+
+	var products []domain.Product
+	err := r.db.Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+		Where("category_id IN ?", ids).Delete(&products).Error
+	if err != nil {
+		log.Printf("Delete category products failed %v.\n", err)
+		return nil, errors.New("failed to delete category products")
+	}
+
+	deleted := make([]uint, 0, len(products))
+	for _, p := range products {
+		deleted = append(deleted, p.ID)
+	}
+
+	return deleted, nil
+}
+
 func (r catalogRepository) FindCategoryById(id uint) (*domain.Category, error) {
 
 	var category *domain.Category
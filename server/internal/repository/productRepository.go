@@ -2,19 +2,30 @@ package repository
 
 import (
 	"ecommerce/internal/domain"
+	"ecommerce/internal/dto"
 	"errors"
 	"log"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductRepository interface {
 	CreateProduct(*domain.Product) (*domain.Product, error)
-	GetProducts() ([]*domain.Product, error)
+	FindProducts(filter dto.ProductFilter) ([]*domain.Product, int64, error)
 	GetProductById(id uint) (*domain.Product, error)
+	FindProductsByIds(ids []uint) ([]*domain.Product, error)
 	EditProduct(*domain.Product) (*domain.Product, error)
 	DeleteProduct(id uint) error
 	FindSellerProducts(sellerId uint) ([]*domain.Product, error)
+	DecrementStock(id uint, qty uint) error
+
+	// Stock reservations
+	ReserveStock(r domain.StockReservation) error
+	ReservedStock(productIds []uint, exceptUserId uint) (map[uint]uint, error)
+	UpdateReservations(orderRef string, from, to domain.ReservationStatus) error
+	FindExpiredReservationRefs(now time.Time) ([]string, error)
 }
 
 type productRepository struct {
@@ -47,6 +58,20 @@ func (p productRepository) CreateProduct(e *domain.Product) (*domain.Product, er
 	return e, nil
 }
 
+// FindProductsByIds implements ProductRepository.
+// Ids without a product are left out of the result.
+func (p productRepository) FindProductsByIds(ids []uint) ([]*domain.Product, error) {
+
+	var products []*domain.Product
+	err := p.db.Where("id IN ?", ids).Find(&products).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, errors.New("error fetching products")
+	}
+
+	return products, nil
+}
+
 // DeleteProduct implements ProductRepository.
 func (p productRepository) DeleteProduct(id uint) error {
 
@@ -95,17 +120,169 @@ func (p productRepository) GetProductById(id uint) (*domain.Product, error) {
 
 }
 
-// GetProducts implements ProductRepository.
-func (p productRepository) GetProducts() ([]*domain.Product, error) {
+// productSortOrders maps dto.ProductFilter sorts to ORDER BY clauses, id
+// keeps pages stable between equal values.
+var productSortOrders = map[string]string{
+	dto.ProductSortNewest:    "created_at desc, id desc",
+	dto.ProductSortPriceAsc:  "price_minor asc, id asc",
+	dto.ProductSortPriceDesc: "price_minor desc, id desc",
+	dto.ProductSortName:      "lower(name) asc, id asc",
+}
+
+// FindProducts implements ProductRepository.
+// It returns one page of the products matching filter and the total count.
+func (p productRepository) FindProducts(filter dto.ProductFilter) ([]*domain.Product, int64, error) {
+
+	query := p.db.Model(&domain.Product{})
+	if len(filter.CategoryIds) > 0 {
+		query = query.Where("category_id IN ?", filter.CategoryIds)
+	}
+	if filter.SellerId > 0 {
+		query = query.Where("user_id = ?", filter.SellerId)
+	}
+	if filter.PriceFrom != nil {
+		query = query.Where("price_currency = ? AND price_minor >= ?", filter.PriceFrom.Currency, filter.PriceFrom.Amount)
+	}
+	if filter.PriceTo != nil {
+		query = query.Where("price_currency = ? AND price_minor <= ?", filter.PriceTo.Currency, filter.PriceTo.Amount)
+	}
+	if filter.InStock {
+		query = query.Where("stock - "+reservedQtySQL+" > 0", domain.ReservationStatusActive, time.Now())
+	}
 
-	var product []*domain.Product
-	err := p.db.Find(&product).Error
+	var total int64
+	err := query.Session(&gorm.Session{}).Count(&total).Error
 	if err != nil {
 		log.Printf("db_error: %v", err)
-		return nil, errors.New("error fetching product of given id")
+		return nil, 0, errors.New("error fetching products")
 	}
 
-	return product, nil
+	order, ok := productSortOrders[filter.Sort]
+	if !ok {
+		order = productSortOrders[dto.ProductSortNewest]
+	}
+
+	var products []*domain.Product
+	err = query.Order(order).Offset(filter.Offset()).Limit(filter.Limit).Find(&products).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, 0, errors.New("error fetching products")
+	}
+
+	return products, total, nil
+}
+
+// reservedQtySQL sums the units of products.id held by other buyers.
+const reservedQtySQL = `(SELECT COALESCE(SUM(qty), 0) FROM stock_reservations
+	WHERE product_id = products.id AND status = ? AND expires_at > ?)`
+
+// DecrementStock implements ProductRepository.
+// The decrement is conditional on enough unreserved stock being left, so
+// concurrent checkouts cannot oversell the product. Convert the buyer's own
+// reservations first so they don't count against the purchase.
+func (p productRepository) DecrementStock(id uint, qty uint) error {
+
+	result := p.db.Model(&domain.Product{}).
+		Where("id=? AND stock - "+reservedQtySQL+" >= ?", id, domain.ReservationStatusActive, time.Now(), qty).
+		Update("stock", gorm.Expr("stock - ?", qty))
+	if result.Error != nil {
+		log.Printf("db_error: %v", result.Error)
+		return errors.New("error updating product stock")
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrorStockNotAvailable
+	}
+
+	return nil
+}
+
+// ReserveStock implements ProductRepository.
+// It locks the product row, so it must run inside a transaction.
+func (p productRepository) ReserveStock(r domain.StockReservation) error {
+
+	var product domain.Product
+	err := p.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, r.ProductId).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return domain.ErrorProductNotFound
+		}
+		return errors.New("error reserving product stock")
+	}
+
+	reserved, err := p.ReservedStock([]uint{r.ProductId}, 0)
+	if err != nil {
+		return err
+	}
+	if product.Stock < reserved[r.ProductId]+r.Qty {
+		return domain.ErrorStockNotAvailable
+	}
+
+	err = p.db.Create(&r).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error reserving product stock")
+	}
+
+	return nil
+}
+
+// ReservedStock implements ProductRepository.
+// Reservations of exceptUserId are left out, 0 counts every buyer.
+func (p productRepository) ReservedStock(productIds []uint, exceptUserId uint) (map[uint]uint, error) {
+
+	var rows []struct {
+		ProductId uint
+		Qty       uint
+	}
+	query := p.db.Model(&domain.StockReservation{}).
+		Select("product_id, SUM(qty) AS qty").
+		Where("product_id IN ? AND status = ? AND expires_at > ?", productIds, domain.ReservationStatusActive, time.Now())
+	if exceptUserId > 0 {
+		query = query.Where("user_id <> ?", exceptUserId)
+	}
+	err := query.Group("product_id").Scan(&rows).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, errors.New("error fetching reserved stock")
+	}
+
+	reserved := map[uint]uint{}
+	for _, row := range rows {
+		reserved[row.ProductId] = row.Qty
+	}
+
+	return reserved, nil
+}
+
+// UpdateReservations implements ProductRepository.
+func (p productRepository) UpdateReservations(orderRef string, from, to domain.ReservationStatus) error {
+
+	err := p.db.Model(&domain.StockReservation{}).
+		Where("order_ref=? AND status=?", orderRef, from).
+		Update("status", to).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error updating stock reservations")
+	}
+
+	return nil
+}
+
+// FindExpiredReservationRefs implements ProductRepository.
+func (p productRepository) FindExpiredReservationRefs(now time.Time) ([]string, error) {
+
+	var refs []string
+	err := p.db.Model(&domain.StockReservation{}).
+		Distinct("order_ref").
+		Where("status=? AND expires_at <= ?", domain.ReservationStatusActive, now).
+		Pluck("order_ref", &refs).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, errors.New("error fetching expired stock reservations")
+	}
+
+	return refs, nil
 }
 
 func NewProductRepository(db *gorm.DB) ProductRepository {
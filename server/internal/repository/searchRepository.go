@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"ecommerce/internal/domain"
+	"ecommerce/internal/dto"
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// SearchRepository finds products by text. The default implementation uses
+// PostgreSQL full text search, another engine can be swapped in by
+// implementing this interface.
+type SearchRepository interface {
+	SearchProducts(query dto.ProductSearchQuery) ([]domain.ProductSearchHit, int64, error)
+	// FindSuggestions loads every product and category name for the
+	// autocomplete index, weighted by order count.
+	FindSuggestions() ([]domain.Suggestion, error)
+}
+
+type searchRepository struct {
+	db *gorm.DB
+}
+
+func NewSearchRepository(db *gorm.DB) SearchRepository {
+	return &searchRepository{
+		db: db,
+	}
+}
+
+// productSearchMatch matches the weighted tsvector of name and description,
+// or names within trigram distance of the query so typos still match.
+const productSearchMatch = `search_vector @@ websearch_to_tsquery('english', @q) OR @q <% name`
+
+// productSearchColumns ranks full text matches above typo matches. The text is
+// HTML escaped before highlighting so only the <mark> tags are markup.
+const productSearchColumns = `products.*,
+	ts_rank_cd(search_vector, websearch_to_tsquery('english', @q)) + word_similarity(@q, name) * 0.5 AS rank,
+	ts_headline('english', replace(replace(replace(name, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		websearch_to_tsquery('english', @q), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS name_highlight,
+	ts_headline('english', replace(replace(replace(description, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		websearch_to_tsquery('english', @q), 'StartSel=<mark>, StopSel=</mark>, MinWords=15, MaxWords=35, MaxFragments=2') AS description_highlight`
+
+type productSearchRow struct {
+	domain.Product       `gorm:"embedded"`
+	Rank                 float64
+	NameHighlight        string
+	DescriptionHighlight string
+}
+
+// SearchProducts implements SearchRepository.
+func (r searchRepository) SearchProducts(query dto.ProductSearchQuery) ([]domain.ProductSearchHit, int64, error) {
+
+	args := map[string]interface{}{"q": query.Query}
+
+	matches := r.db.Model(&domain.Product{}).Where(productSearchMatch, args)
+
+	var total int64
+	err := matches.Session(&gorm.Session{}).Count(&total).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, 0, errors.New("error searching products")
+	}
+
+	var rows []productSearchRow
+	err = matches.Select(productSearchColumns, args).
+		Order("rank desc, id desc").
+		Offset(query.Offset()).
+		Limit(query.Limit).
+		Scan(&rows).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, 0, errors.New("error searching products")
+	}
+
+	hits := make([]domain.ProductSearchHit, 0, len(rows))
+	for _, row := range rows {
+		hits = append(hits, domain.ProductSearchHit{
+			Product:              row.Product,
+			Rank:                 row.Rank,
+			NameHighlight:        row.NameHighlight,
+			DescriptionHighlight: row.DescriptionHighlight,
+		})
+	}
+
+	return hits, total, nil
+}
+
+// suggestionsSQL weights product and category names by the number of orders
+// that contain the product, or any product of the category. The kinds are
+// domain.SuggestionProduct and domain.SuggestionCategory.
+const suggestionsSQL = `SELECT 'product' AS kind, products.id, products.name AS text,
+		COUNT(DISTINCT order_items.order_id) AS weight
+	FROM products
+	LEFT JOIN order_items ON order_items.product_id = products.id
+	GROUP BY products.id
+	UNION ALL
+	SELECT 'category' AS kind, categories.id, categories.name AS text,
+		COUNT(DISTINCT order_items.order_id) AS weight
+	FROM categories
+	LEFT JOIN products ON products.category_id = categories.id
+	LEFT JOIN order_items ON order_items.product_id = products.id
+	GROUP BY categories.id`
+
+// FindSuggestions implements SearchRepository.
+func (r searchRepository) FindSuggestions() ([]domain.Suggestion, error) {
+
+	var suggestions []domain.Suggestion
+	err := r.db.Raw(suggestionsSQL).Scan(&suggestions).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, errors.New("error loading suggestions")
+	}
+
+	return suggestions, nil
+}
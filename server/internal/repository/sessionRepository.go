@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"ecommerce/internal/domain"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepository interface {
+	CreateSession(s domain.Session, t domain.RefreshToken) error
+	FindSession(id string) (*domain.Session, error)
+	IsSessionActive(id string) (bool, error)
+	RevokeSession(id string) error
+	RevokeUserSessions(userId uint) error
+
+	FindRefreshToken(hash string) (*domain.RefreshToken, error)
+	RotateRefreshToken(used *domain.RefreshToken, next domain.RefreshToken) error
+
+	// Password reset
+	CreatePasswordResetToken(t domain.PasswordResetToken) error
+	FindPasswordResetToken(hash string) (*domain.PasswordResetToken, error)
+	ConsumePasswordResetToken(t *domain.PasswordResetToken) error
+}
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+// CreateSession implements SessionRepository.
+func (r sessionRepository) CreateSession(s domain.Session, t domain.RefreshToken) error {
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&s).Error; err != nil {
+			return err
+		}
+		return tx.Create(&t).Error
+	})
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error creating session")
+	}
+
+	return nil
+}
+
+// FindSession implements SessionRepository.
+func (r sessionRepository) FindSession(id string) (*domain.Session, error) {
+
+	var session *domain.Session
+	err := r.db.First(&session, "id=?", id).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorSessionRevoked
+		}
+		return nil, errors.New("error fetching session")
+	}
+
+	return session, nil
+}
+
+// IsSessionActive implements SessionRepository.
+func (r sessionRepository) IsSessionActive(id string) (bool, error) {
+
+	var count int64
+	err := r.db.Model(&domain.Session{}).
+		Where("id=? AND revoked_at IS NULL", id).
+		Count(&count).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return false, errors.New("error fetching session")
+	}
+
+	return count > 0, nil
+}
+
+// RevokeSession implements SessionRepository.
+func (r sessionRepository) RevokeSession(id string) error {
+
+	err := r.db.Model(&domain.Session{}).
+		Where("id=? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error revoking session")
+	}
+
+	return nil
+}
+
+// RevokeUserSessions implements SessionRepository.
+func (r sessionRepository) RevokeUserSessions(userId uint) error {
+
+	err := r.db.Model(&domain.Session{}).
+		Where("user_id=? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error revoking sessions")
+	}
+
+	return nil
+}
+
+// FindRefreshToken implements SessionRepository.
+func (r sessionRepository) FindRefreshToken(hash string) (*domain.RefreshToken, error) {
+
+	var token *domain.RefreshToken
+	err := r.db.First(&token, "token_hash=?", hash).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorInvalidRefreshToken
+		}
+		return nil, errors.New("error fetching refresh token")
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken implements SessionRepository.
+// Marking the used token is conditional, so of two concurrent refreshes with
+// the same token only one succeeds and the other reports reuse.
+func (r sessionRepository) RotateRefreshToken(used *domain.RefreshToken, next domain.RefreshToken) error {
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&domain.RefreshToken{}).
+			Where("id=? AND used_at IS NULL", used.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return domain.ErrorRefreshTokenReused
+		}
+		return tx.Create(&next).Error
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrorRefreshTokenReused) {
+			return err
+		}
+		log.Printf("db_error: %v", err)
+		return errors.New("error rotating refresh token")
+	}
+
+	return nil
+}
+
+// CreatePasswordResetToken implements SessionRepository.
+func (r sessionRepository) CreatePasswordResetToken(t domain.PasswordResetToken) error {
+
+	err := r.db.Create(&t).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error creating password reset token")
+	}
+
+	return nil
+}
+
+// FindPasswordResetToken implements SessionRepository.
+func (r sessionRepository) FindPasswordResetToken(hash string) (*domain.PasswordResetToken, error) {
+
+	var token *domain.PasswordResetToken
+	err := r.db.First(&token, "token_hash=?", hash).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorInvalidResetToken
+		}
+		return nil, errors.New("error fetching password reset token")
+	}
+
+	return token, nil
+}
+
+// ConsumePasswordResetToken implements SessionRepository.
+// It marks t used, failing if it already was, along with every other
+// outstanding token of the user.
+func (r sessionRepository) ConsumePasswordResetToken(t *domain.PasswordResetToken) error {
+
+	now := time.Now()
+
+	result := r.db.Model(&domain.PasswordResetToken{}).
+		Where("id=? AND used_at IS NULL", t.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		log.Printf("db_error: %v", result.Error)
+		return errors.New("error using password reset token")
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrorInvalidResetToken
+	}
+
+	err := r.db.Model(&domain.PasswordResetToken{}).
+		Where("user_id=? AND used_at IS NULL", t.UserId).
+		Update("used_at", now).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error using password reset token")
+	}
+
+	return nil
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{
+		db: db,
+	}
+}
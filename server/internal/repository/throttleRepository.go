@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"ecommerce/internal/domain"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ThrottleRepository interface {
+	FindThrottles(keys []string) ([]*domain.AuthThrottle, error)
+	RegisterAttempt(key string, policy domain.ThrottlePolicy) (*domain.AuthThrottle, error)
+	ResetThrottle(key string) error
+}
+
+type throttleRepository struct {
+	db *gorm.DB
+}
+
+// FindThrottles implements ThrottleRepository.
+func (r throttleRepository) FindThrottles(keys []string) ([]*domain.AuthThrottle, error) {
+
+	var throttles []*domain.AuthThrottle
+	err := r.db.Where("key IN ?", keys).Find(&throttles).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, errors.New("error fetching attempt counters")
+	}
+
+	return throttles, nil
+}
+
+// RegisterAttempt implements ThrottleRepository.
+// The counter row is locked while it is updated so concurrent attempts are
+// all counted.
+func (r throttleRepository) RegisterAttempt(key string, policy domain.ThrottlePolicy) (*domain.AuthThrottle, error) {
+
+	var throttle domain.AuthThrottle
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&domain.AuthThrottle{Key: key}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&throttle, "key=?", key).Error
+		if err != nil {
+			return err
+		}
+
+		policy.Register(&throttle, time.Now())
+
+		return tx.Save(&throttle).Error
+	})
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return nil, errors.New("error registering attempt")
+	}
+
+	return &throttle, nil
+}
+
+// ResetThrottle implements ThrottleRepository.
+func (r throttleRepository) ResetThrottle(key string) error {
+
+	err := r.db.Delete(&domain.AuthThrottle{}, "key=?", key).Error
+	if err != nil {
+		log.Printf("db_error: %v", err)
+		return errors.New("error resetting attempt counter")
+	}
+
+	return nil
+}
+
+func NewThrottleRepository(db *gorm.DB) ThrottleRepository {
+	return &throttleRepository{
+		db: db,
+	}
+}
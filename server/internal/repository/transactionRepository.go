@@ -13,8 +13,19 @@ type TransactionRepository interface {
 	CreatePayment(payment *domain.Payment) error
 	FindInitialPayment(u uint) (*domain.Payment, error)
 	UpdatePayment(payment *domain.Payment) error
-	FindOrders(uID uint) ([]domain.Order, error)
-	FindOrderById(orderId, uID uint) (dto.SellerOrderDetails, error)
+	FindPaymentByPaymentId(pId string) (*domain.Payment, error)
+	FindLatestPayment(u uint) (*domain.Payment, error)
+	FindPaymentByOrderRef(ref string) (*domain.Payment, error)
+	CreateCheckoutSnapshot(snapshot *domain.CheckoutSnapshot) error
+	FindCheckoutSnapshot(orderRef string) (*domain.CheckoutSnapshot, error)
+	UpdatePaymentStatus(payment *domain.Payment, status domain.PaymentStatus, response string) error
+	FindOrders(sellerId uint, filter dto.SellerOrderFilter) ([]dto.SellerOrderDetails, int64, error)
+	FindOrderById(orderItemId, sellerId uint) (dto.SellerOrderDetails, error)
+	FindOrderByRef(ref string) (*domain.Order, error)
+	UpdateOrderStatus(order *domain.Order, history domain.OrderStatusHistory) error
+	ReserveRefunds(refunds []domain.Refund) error
+	ReleaseRefunds(refunds []domain.Refund) error
+	CompleteRefunds(refunds []domain.Refund, gatewayRefundId string, payment *domain.Payment, paymentStatus domain.PaymentStatus, order *domain.Order, history *domain.OrderStatusHistory) error
 }
 
 type transactionRepository struct {
@@ -37,7 +48,7 @@ func (r *transactionRepository) UpdatePayment(payment *domain.Payment) error {
 // FindPayment implements TransactionRepository.
 func (r *transactionRepository) FindInitialPayment(u uint) (*domain.Payment, error) {
 	var payment *domain.Payment
-	err := r.db.First(&payment, "user_id=? AND status=?", u, "initial").Order("created_at desc").Error
+	err := r.db.Order("created_at desc").First(&payment, "user_id=? AND status=?", u, domain.PaymentStatusInitial).Error
 	if err != nil {
 		fmt.Printf("data base error cccured %v", err)
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -48,6 +59,91 @@ func (r *transactionRepository) FindInitialPayment(u uint) (*domain.Payment, err
 	return payment, nil
 }
 
+// FindPaymentByPaymentId implements TransactionRepository.
+func (r *transactionRepository) FindPaymentByPaymentId(pId string) (*domain.Payment, error) {
+	var payment *domain.Payment
+	err := r.db.First(&payment, "payment_id=?", pId).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorPaymentNotFound
+		}
+		return nil, errors.New("some error occured")
+	}
+	return payment, nil
+}
+
+// FindPaymentByOrderRef implements TransactionRepository.
+func (r *transactionRepository) FindPaymentByOrderRef(ref string) (*domain.Payment, error) {
+	var payment *domain.Payment
+	err := r.db.First(&payment, "order_id=?", ref).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorPaymentNotFound
+		}
+		return nil, errors.New("some error occured")
+	}
+	return payment, nil
+}
+
+// CreateCheckoutSnapshot implements TransactionRepository.
+func (r *transactionRepository) CreateCheckoutSnapshot(snapshot *domain.CheckoutSnapshot) error {
+	err := r.db.Create(snapshot).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		return errors.New("failed to store checkout snapshot")
+	}
+	return nil
+}
+
+// FindCheckoutSnapshot implements TransactionRepository.
+func (r *transactionRepository) FindCheckoutSnapshot(orderRef string) (*domain.CheckoutSnapshot, error) {
+	var snapshot *domain.CheckoutSnapshot
+	err := r.db.Preload("Items").First(&snapshot, "order_ref=?", orderRef).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorCheckoutSnapshotNotFound
+		}
+		return nil, errors.New("some error occured")
+	}
+	return snapshot, nil
+}
+
+// FindLatestPayment implements TransactionRepository.
+func (r *transactionRepository) FindLatestPayment(u uint) (*domain.Payment, error) {
+	var payment *domain.Payment
+	err := r.db.Order("created_at desc").First(&payment, "user_id=?", u).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorPaymentNotFound
+		}
+		return nil, errors.New("some error occured")
+	}
+	return payment, nil
+}
+
+// UpdatePaymentStatus implements TransactionRepository.
+// The update is conditional on the stored status so that the browser
+// verification and the payment webhook can race without a late "failed"
+// overwriting a "success".
+func (r *transactionRepository) UpdatePaymentStatus(payment *domain.Payment, status domain.PaymentStatus, response string) error {
+	result := r.db.Model(&domain.Payment{}).
+		Where("id=? AND status IN ?", payment.ID, domain.PaymentStatusesBefore(status)).
+		Updates(map[string]interface{}{"status": status, "response": response})
+	if result.Error != nil {
+		fmt.Printf("data base error cccured %v", result.Error)
+		return errors.New("some error occured")
+	}
+	if result.RowsAffected > 0 {
+		payment.Status = status
+		payment.Response = response
+	}
+	return nil
+}
+
 func NewTransactionRepository(db *gorm.DB) TransactionRepository {
 	return &transactionRepository{
 		db: db,
@@ -58,10 +154,240 @@ func (r *transactionRepository) CreatePayment(payment *domain.Payment) error {
 	return r.db.Create(payment).Error
 }
 
-func (r *transactionRepository) FindOrders(uID uint) ([]domain.Order, error) {
-	return []domain.Order{}, nil
+// sellerOrderColumns maps the joined rows of sellerOrderItems onto dto.SellerOrderDetails.
+const sellerOrderColumns = `order_items.id AS order_item_id,
+	order_items.product_id,
+	order_items.name,
+	order_items.image_url,
+	order_items.price_minor,
+	order_items.price_currency,
+	order_items.qty,
+	orders.order_ref AS order_ref_number,
+	orders.status AS order_status,
+	orders.created_at,
+	concat_ws(' ', NULLIF(users.first_name, ''), NULLIF(users.last_name, '')) AS customer_name,
+	users.email AS customer_email,
+	users.phone AS customer_phone,
+	concat_ws(', ', NULLIF(orders.shipping_address_line1, ''), NULLIF(orders.shipping_address_line2, ''),
+		NULLIF(orders.shipping_city, ''), NULLIF(orders.shipping_post_code, 0), NULLIF(orders.shipping_country, '')) AS customer_address`
+
+// sellerOrderItems scopes order items to the given seller and joins the
+// order and customer needed by the seller dashboard. The shipping address is
+// the one snapshotted onto the order.
+func (r *transactionRepository) sellerOrderItems(sellerId uint) *gorm.DB {
+	return r.db.Table("order_items").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Joins("JOIN users ON users.id = orders.user_id").
+		Where("order_items.seller_id = ?", sellerId)
+}
+
+func (r *transactionRepository) FindOrders(sellerId uint, filter dto.SellerOrderFilter) ([]dto.SellerOrderDetails, int64, error) {
+
+	query := r.sellerOrderItems(sellerId)
+	if filter.Status != "" {
+		query = query.Where("orders.status = ?", filter.Status)
+	}
+
+	var total int64
+	err := query.Session(&gorm.Session{}).Count(&total).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		return nil, 0, errors.New("failed to fetch seller orders")
+	}
+
+	orders := []dto.SellerOrderDetails{}
+	err = query.Select(sellerOrderColumns).
+		Order("orders.created_at desc, order_items.id desc").
+		Offset(filter.Offset()).
+		Limit(filter.Limit).
+		Scan(&orders).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		return nil, 0, errors.New("failed to fetch seller orders")
+	}
+
+	return orders, total, nil
+}
+
+func (r *transactionRepository) FindOrderById(orderItemId, sellerId uint) (dto.SellerOrderDetails, error) {
+
+	var order dto.SellerOrderDetails
+	err := r.sellerOrderItems(sellerId).
+		Select(sellerOrderColumns).
+		Where("order_items.id = ?", orderItemId).
+		Take(&order).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return order, domain.ErrorOrderNotFound
+		}
+		return order, errors.New("failed to fetch seller order")
+	}
+
+	return order, nil
+}
+
+func (r *transactionRepository) FindOrderByRef(ref string) (*domain.Order, error) {
+
+	var order domain.Order
+	err := r.db.Preload("Items").Preload("History").Preload("Refunds").Where("order_ref=?", ref).First(&order).Error
+	if err != nil {
+		fmt.Printf("data base error cccured %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrorOrderNotFound
+		}
+		return nil, errors.New("failed to fetch order")
+	}
+
+	return &order, nil
+}
+
+// UpdateOrderStatus moves the order from history.FromStatus to history.ToStatus
+// and records the transition. The update only applies while the order is still
+// in FromStatus so concurrent transitions cannot both succeed.
+func (r *transactionRepository) UpdateOrderStatus(order *domain.Order, history domain.OrderStatusHistory) error {
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+
+		result := tx.Model(&domain.Order{}).
+			Where("id=? AND status=?", order.ID, history.FromStatus).
+			Update("status", history.ToStatus)
+		if result.Error != nil {
+			fmt.Printf("data base error cccured %v", result.Error)
+			return errors.New("failed to update order status")
+		}
+		if result.RowsAffected == 0 {
+			return domain.OrderTransitionError{From: history.FromStatus, To: history.ToStatus}
+		}
+
+		history.OrderId = order.ID
+		if err := tx.Create(&history).Error; err != nil {
+			fmt.Printf("data base error cccured %v", err)
+			return errors.New("failed to record order status history")
+		}
+
+		order.Status = history.ToStatus
+		order.History = append(order.History, history)
+		return nil
+	})
+}
+
+// ReserveRefunds marks the refund quantities as refunded on the order items
+// and stores the refunds as pending, all or nothing. The quantity update is
+// conditional, so a request that would refund more than is left fails with
+// ErrorRefundExceedsQuantity even when it races another one.
+func (r *transactionRepository) ReserveRefunds(refunds []domain.Refund) error {
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+
+		for _, refund := range refunds {
+
+			result := tx.Model(&domain.OrderItem{}).
+				Where("id=? AND refunded_qty + ? <= qty", refund.OrderItemId, refund.Qty).
+				Update("refunded_qty", gorm.Expr("refunded_qty + ?", refund.Qty))
+			if result.Error != nil {
+				fmt.Printf("data base error cccured %v", result.Error)
+				return errors.New("failed to update refunded quantity")
+			}
+			if result.RowsAffected == 0 {
+				return domain.ErrorRefundExceedsQuantity
+			}
+		}
+
+		for i := range refunds {
+			refunds[i].Status = domain.RefundStatusPending
+		}
+
+		if err := tx.Create(&refunds).Error; err != nil {
+			fmt.Printf("data base error cccured %v", err)
+			return errors.New("failed to record refunds")
+		}
+
+		return nil
+	})
+}
+
+// ReleaseRefunds gives the quantities of pending refunds back to the order
+// items and marks the refunds as failed.
+func (r *transactionRepository) ReleaseRefunds(refunds []domain.Refund) error {
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+
+		for _, refund := range refunds {
+
+			result := tx.Model(&domain.Refund{}).
+				Where("id=? AND status=?", refund.ID, domain.RefundStatusPending).
+				Update("status", domain.RefundStatusFailed)
+			if result.Error != nil {
+				fmt.Printf("data base error cccured %v", result.Error)
+				return errors.New("failed to release refund")
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			err := tx.Model(&domain.OrderItem{}).
+				Where("id=?", refund.OrderItemId).
+				Update("refunded_qty", gorm.Expr("refunded_qty - ?", refund.Qty)).Error
+			if err != nil {
+				fmt.Printf("data base error cccured %v", err)
+				return errors.New("failed to update refunded quantity")
+			}
+		}
+
+		return nil
+	})
+}
+
+// CompleteRefunds records the gateway refund on pending refunds and, in the
+// same transaction, puts the items back in stock and moves the payment to
+// paymentStatus. When history is set the order moves to history.ToStatus as
+// well.
+func (r *transactionRepository) CompleteRefunds(refunds []domain.Refund, gatewayRefundId string, payment *domain.Payment, paymentStatus domain.PaymentStatus, order *domain.Order, history *domain.OrderStatusHistory) error {
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+
+		for _, refund := range refunds {
+
+			result := tx.Model(&domain.Refund{}).
+				Where("id=? AND status=?", refund.ID, domain.RefundStatusPending).
+				Updates(map[string]interface{}{"status": domain.RefundStatusSucceeded, "gateway_refund_id": gatewayRefundId})
+			if result.Error != nil {
+				fmt.Printf("data base error cccured %v", result.Error)
+				return errors.New("failed to record refunds")
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			// the product may have been deleted since the order was placed
+			err := tx.Model(&domain.Product{}).
+				Where("id=?", refund.ProductId).
+				Update("stock", gorm.Expr("stock + ?", refund.Qty)).Error
+			if err != nil {
+				fmt.Printf("data base error cccured %v", err)
+				return errors.New("failed to restore product stock")
+			}
+		}
+
+		err := tx.Model(&domain.Payment{}).
+			Where("id=? AND status IN ?", payment.ID, domain.PaymentStatusesBefore(paymentStatus)).
+			Update("status", paymentStatus).Error
+		if err != nil {
+			fmt.Printf("data base error cccured %v", err)
+			return errors.New("failed to update payment status")
+		}
+		payment.Status = paymentStatus
+
+		if history != nil {
+			return r.withTx(tx).UpdateOrderStatus(order, *history)
+		}
+
+		return nil
+	})
 }
 
-func (r *transactionRepository) FindOrderById(orderId, uID uint) (dto.SellerOrderDetails, error) {
-	return dto.SellerOrderDetails{}, nil
+// withTx returns a repository whose queries run inside tx.
+func (r *transactionRepository) withTx(tx *gorm.DB) *transactionRepository {
+	return &transactionRepository{db: tx}
 }
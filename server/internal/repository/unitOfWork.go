@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+)
+
+// Repositories groups repositories that share one database transaction.
+type Repositories struct {
+	User        UserRepository
+	Product     ProductRepository
+	Catalog     CatalogRepository
+	Transaction TransactionRepository
+	Session     SessionRepository
+}
+
+// UnitOfWork lets services compose operations from several repositories into
+// a single transaction. Returning an error from fn rolls everything back.
+type UnitOfWork interface {
+	Do(fn func(repos Repositories) error) error
+}
+
+type unitOfWork struct {
+	db *gorm.DB
+}
+
+// Do implements UnitOfWork.
+func (u *unitOfWork) Do(fn func(repos Repositories) error) error {
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		return fn(Repositories{
+			User:        NewUserRepository(tx),
+			Product:     NewProductRepository(tx),
+			Catalog:     NewCatalogRepository(tx),
+			Transaction: NewTransactionRepository(tx),
+			Session:     NewSessionRepository(tx),
+		})
+	})
+}
+
+func NewUnitOfWork(db *gorm.DB) UnitOfWork {
+	return &unitOfWork{
+		db: db,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"ecommerce/internal/domain"
 	"errors"
 	"log"
+	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -13,7 +14,16 @@ type UserRepository interface {
 	CreateUser(u domain.User) (domain.User, error)
 	FindUser(email string) (domain.User, error)
 	FindUserById(id uint) (domain.User, error)
+	IsUserVerified(id uint) (bool, error)
 	UpdateUser(id uint, u domain.User) (domain.User, error)
+	UpdateEmail(id uint, email string) error
+	UpdateSuspended(id uint, suspended bool) error
+
+	// Two factor authentication
+	UpdateTwoFactor(id uint, secret string, enabled bool) error
+	UpdateTotpLastStep(id uint, step int64) error
+	ReplaceRecoveryCodes(uId uint, codes []domain.RecoveryCode) error
+	UseRecoveryCode(uId uint, hash string) error
 	CreateBankAccount(e domain.BankAccount) error
 
 	//cart
@@ -23,6 +33,7 @@ type UserRepository interface {
 	UpdateCart(c domain.Cart) error
 	DeleteCartById(id uint) error
 	DeleteCartItems(uId uint) error
+	DeleteCartProducts(uId uint, productIds []uint) error
 
 	// Order
 	CreateOrder(o domain.Order) error
@@ -30,9 +41,13 @@ type UserRepository interface {
 	FindOrderById(id uint) (domain.Order, error)
 	FindUserOrderById(id string, uId uint) (domain.Order, error)
 
-	// Profile
-	CreateAddress(e domain.Address) error
+	// Address book
+	FindAddresses(uId uint) ([]domain.Address, error)
+	FindAddressById(id, uId uint) (domain.Address, error)
+	CreateAddress(e domain.Address) (*domain.Address, error)
 	UpdateAddress(e domain.Address) (*domain.Address, error)
+	DeleteAddress(id, uId uint) error
+	ClearDefaultAddress(uId uint, shipping, billing bool) error
 }
 
 type userRepository struct {
@@ -42,7 +57,7 @@ type userRepository struct {
 // FindUserOrderById implements UserRepository.
 func (r *userRepository) FindUserOrderById(id string, uId uint) (domain.Order, error) {
 	var order domain.Order
-	err := r.db.Where("order_ref=? AND user_id=?", id, uId).First(&order).Error
+	err := r.db.Preload("Items").Preload("History").Preload("Refunds").Where("order_ref=? AND user_id=?", id, uId).First(&order).Error
 	if err != nil {
 		log.Printf("find order by user id error %v", err)
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -71,7 +86,7 @@ func (r *userRepository) FindOrderById(id uint) (domain.Order, error) {
 func (r *userRepository) FindOrders(uid uint) ([]domain.Order, error) {
 
 	var orders []domain.Order
-	err := r.db.Preload("Itens").Where("user_id=?", uid).Find(&orders).Error
+	err := r.db.Preload("Items").Where("user_id=?", uid).Find(&orders).Error
 	if err != nil {
 		log.Printf("find order by user id error %v", err)
 		return orders, errors.New("failed to fetch orders")
@@ -90,27 +105,93 @@ func (r *userRepository) CreateOrder(o domain.Order) error {
 	return nil
 }
 
+// FindAddresses implements UserRepository.
+func (r *userRepository) FindAddresses(uId uint) ([]domain.Address, error) {
+	var addresses []domain.Address
+	err := r.db.Where("user_id=?", uId).Order("id").Find(&addresses).Error
+	if err != nil {
+		log.Printf("find addresses error : %v", err)
+		return nil, errors.New("error fetching addresses")
+	}
+	return addresses, nil
+}
+
+// FindAddressById implements UserRepository.
+func (r *userRepository) FindAddressById(id, uId uint) (domain.Address, error) {
+	var address domain.Address
+	err := r.db.Where("id=? AND user_id=?", id, uId).First(&address).Error
+	if err != nil {
+		log.Printf("find address error : %v", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return address, domain.ErrorAddressNotFound
+		}
+		return address, errors.New("error fetching address")
+	}
+	return address, nil
+}
+
 // CreateAddress implements UserRepository.
-func (r *userRepository) CreateAddress(e domain.Address) error {
+func (r *userRepository) CreateAddress(e domain.Address) (*domain.Address, error) {
 	err := r.db.Create(&e).Error
 	if err != nil {
 		log.Printf("create profile address error : %v", err)
-		return errors.New("error creating profile address")
+		return nil, errors.New("error creating profile address")
 	}
-	return nil
+	return &e, nil
 }
 
-// UpdateAddress implements UserRepository.
+// UpdateAddress implements UserRepository. Zero fields are left unchanged.
 func (r *userRepository) UpdateAddress(e domain.Address) (*domain.Address, error) {
-	err := r.db.Where("user_id=?", e.UserId).Updates(&e).Error
-	if err != nil {
-		log.Printf("update profile address error : %v", err)
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, domain.ErrorUserNotFound
-		}
+	res := r.db.Model(&domain.Address{}).Where("id=? AND user_id=?", e.ID, e.UserId).Updates(&e)
+	if res.Error != nil {
+		log.Printf("update profile address error : %v", res.Error)
 		return nil, errors.New("error updating profile address")
 	}
-	return &e, nil
+	if res.RowsAffected == 0 {
+		return nil, domain.ErrorAddressNotFound
+	}
+
+	address, err := r.FindAddressById(e.ID, e.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// DeleteAddress implements UserRepository. Orders keep their own copy of the
+// address, so it can always be deleted.
+func (r *userRepository) DeleteAddress(id, uId uint) error {
+	res := r.db.Where("id=? AND user_id=?", id, uId).Delete(&domain.Address{})
+	if res.Error != nil {
+		log.Printf("delete address error : %v", res.Error)
+		return errors.New("error deleting address")
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrorAddressNotFound
+	}
+	return nil
+}
+
+// ClearDefaultAddress implements UserRepository.
+func (r *userRepository) ClearDefaultAddress(uId uint, shipping, billing bool) error {
+
+	updates := map[string]interface{}{}
+	if shipping {
+		updates["is_default_shipping"] = false
+	}
+	if billing {
+		updates["is_default_billing"] = false
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	err := r.db.Model(&domain.Address{}).Where("user_id=?", uId).Updates(updates).Error
+	if err != nil {
+		log.Printf("clear default address error : %v", err)
+		return errors.New("error updating addresses")
+	}
+	return nil
 }
 
 // DeleteCartItems implements UserRepository.
@@ -126,6 +207,16 @@ func (r *userRepository) DeleteCartItems(uId uint) error {
 	return nil
 }
 
+// DeleteCartProducts implements UserRepository.
+func (r *userRepository) DeleteCartProducts(uId uint, productIds []uint) error {
+	err := r.db.Where("user_id=? AND product_id IN ?", uId, productIds).Delete(&domain.Cart{}).Error
+	if err != nil {
+		log.Printf("delete cart items error : %v", err)
+		return errors.New("error deleting cart items")
+	}
+	return nil
+}
+
 // CreateCart implements UserRepository.
 func (r *userRepository) CreateCart(c domain.Cart) error {
 	err := r.db.Create(&c).Error
@@ -213,7 +304,7 @@ func (r userRepository) FindUser(email string) (domain.User, error) {
 
 	var user domain.User
 
-	err := r.db.Preload("Address").First(&user, "email=?", email).Error
+	err := r.db.Preload("Addresses").First(&user, "email=?", email).Error
 	if err != nil {
 		log.Printf("find user by email error %v", err)
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -228,7 +319,7 @@ func (r userRepository) FindUser(email string) (domain.User, error) {
 func (r userRepository) FindUserById(id uint) (domain.User, error) {
 	var user domain.User
 
-	err := r.db.Preload("Address").
+	err := r.db.Preload("Addresses").
 		Preload("Cart").
 		Preload("Orders").
 		First(&user, id).Error
@@ -259,6 +350,118 @@ func (r userRepository) UpdateUser(id uint, u domain.User) (domain.User, error)
 	return user, nil
 }
 
+// UpdateEmail implements UserRepository.
+// The new address is unverified until the user confirms it again.
+func (r userRepository) UpdateEmail(id uint, email string) error {
+
+	err := r.db.Model(&domain.User{}).Where("id=?", id).Updates(map[string]interface{}{
+		"email":    email,
+		"verified": false,
+	}).Error
+	if err != nil {
+		log.Printf("error on update email %v", err)
+		return errors.New("failed update user email")
+	}
+
+	return nil
+}
+
+// IsUserVerified implements UserRepository.
+func (r userRepository) IsUserVerified(id uint) (bool, error) {
+
+	var verified bool
+	err := r.db.Model(&domain.User{}).Where("id=?", id).Select("verified").Scan(&verified).Error
+	if err != nil {
+		log.Printf("find user verified error %v", err)
+		return false, errors.New("error fetching user")
+	}
+
+	return verified, nil
+}
+
+// UpdateSuspended implements UserRepository.
+func (r userRepository) UpdateSuspended(id uint, suspended bool) error {
+
+	err := r.db.Model(&domain.User{}).Where("id=?", id).Update("suspended", suspended).Error
+	if err != nil {
+		log.Printf("error on update suspended %v", err)
+		return errors.New("failed update user")
+	}
+
+	return nil
+}
+
+// UpdateTwoFactor implements UserRepository.
+func (r userRepository) UpdateTwoFactor(id uint, secret string, enabled bool) error {
+
+	err := r.db.Model(&domain.User{}).Where("id=?", id).Updates(map[string]interface{}{
+		"totp_secret":        secret,
+		"totp_last_step":     0,
+		"two_factor_enabled": enabled,
+	}).Error
+	if err != nil {
+		log.Printf("error on update two factor %v", err)
+		return errors.New("failed update two factor settings")
+	}
+
+	return nil
+}
+
+// UpdateTotpLastStep implements UserRepository.
+// It only moves forward, so of two requests with the same code one fails.
+func (r userRepository) UpdateTotpLastStep(id uint, step int64) error {
+
+	result := r.db.Model(&domain.User{}).
+		Where("id=? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		log.Printf("error on update totp step %v", result.Error)
+		return errors.New("failed update two factor settings")
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrorInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes implements UserRepository.
+func (r userRepository) ReplaceRecoveryCodes(uId uint, codes []domain.RecoveryCode) error {
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id=?", uId).Delete(&domain.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		log.Printf("error on replace recovery codes %v", err)
+		return errors.New("failed update recovery codes")
+	}
+
+	return nil
+}
+
+// UseRecoveryCode implements UserRepository.
+func (r userRepository) UseRecoveryCode(uId uint, hash string) error {
+
+	result := r.db.Model(&domain.RecoveryCode{}).
+		Where("user_id=? AND code_hash=? AND used_at IS NULL", uId, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		log.Printf("error on use recovery code %v", result.Error)
+		return errors.New("failed to use recovery code")
+	}
+	if result.RowsAffected == 0 {
+		return domain.ErrorInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
 func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{
 		db: db,
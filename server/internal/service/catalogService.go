@@ -6,23 +6,51 @@ import (
 	"ecommerce/internal/dto"
 	"ecommerce/internal/helper"
 	"ecommerce/internal/repository"
+	"ecommerce/pkg/suggest"
+	"errors"
 )
 
 type CatalogService struct {
 	Repo   repository.CatalogRepository
+	Uow    repository.UnitOfWork
 	Auth   helper.Auth
 	Config config.AppConfig
+
+	// Si is the autocomplete index shared with ProductService.
+	Si *suggest.Index
 }
 
 func (s CatalogService) CreateCategory(input dto.CreateCategoryDTO) error {
 
-	err := s.Repo.CreateCategory(&domain.Category{
+	if input.ParentId > 0 {
+		if _, err := s.Repo.FindCategoryById(input.ParentId); err != nil {
+			if errors.Is(err, domain.ErrorCategoryNotFound) {
+				return domain.ErrorParentCategoryNotFound
+			}
+			return err
+		}
+	}
+
+	category := &domain.Category{
 		Name:         input.Name,
+		ParentId:     input.ParentId,
 		ImageUrl:     input.ImageUrl,
 		DisplayOrder: input.DisplayOrder,
-	})
+	}
+	err := s.Repo.CreateCategory(category)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if s.Si != nil {
+		s.Si.Upsert(suggest.Entry{
+			Kind: domain.SuggestionCategory,
+			ID:   category.ID,
+			Text: category.Name,
+		})
+	}
+
+	return nil
 }
 
 func (s CatalogService) GetCategories() ([]*domain.Category, error) {
@@ -35,6 +63,39 @@ func (s CatalogService) GetCategories() ([]*domain.Category, error) {
 	return categories, nil
 }
 
+// GetCategoryTree returns the root categories with their subcategories
+// nested, siblings ordered by DisplayOrder.
+func (s CatalogService) GetCategoryTree() ([]*domain.Category, error) {
+
+	categories, err := s.Repo.FindCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	return buildCategoryTree(categories), nil
+}
+
+// GetBreadcrumbs returns the path from the root category down to id.
+func (s CatalogService) GetBreadcrumbs(id uint) ([]dto.CategoryBreadcrumb, error) {
+
+	categories, err := s.Repo.FindCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	byId := categoriesById(categories)
+	if _, ok := byId[id]; !ok {
+		return nil, domain.ErrorCategoryNotFound
+	}
+
+	var crumbs []dto.CategoryBreadcrumb
+	for _, cat := range ancestorsOf(byId, id) {
+		crumbs = append([]dto.CategoryBreadcrumb{{ID: cat.ID, Name: cat.Name}}, crumbs...)
+	}
+
+	return crumbs, nil
+}
+
 func (s CatalogService) GetCategory(id uint) (*domain.Category, error) {
 
 	cat, err := s.Repo.FindCategoryById(id)
@@ -44,9 +105,88 @@ func (s CatalogService) GetCategory(id uint) (*domain.Category, error) {
 	return cat, nil
 }
 
-func (s CatalogService) DeleteCategory(id uint) error {
+// DeleteCategory deletes a category, mode decides what happens to its
+// subcategories and products.
+func (s CatalogService) DeleteCategory(id uint, mode domain.CategoryDeleteMode) error {
+
+	if !mode.IsValid() {
+		return domain.ErrorInvalidDeleteMode
+	}
+
+	// what to drop from the autocomplete index once the delete went through
+	deletedCategoryIds := []uint{id}
+	var deletedProductIds []uint
 
-	return s.Repo.DeleteCategory(id)
+	err := s.Uow.Do(func(repos repository.Repositories) error {
+
+		categories, err := repos.Catalog.LockCategories()
+		if err != nil {
+			return err
+		}
+
+		cat, ok := categoriesById(categories)[id]
+		if !ok {
+			return domain.ErrorCategoryNotFound
+		}
+
+		switch mode {
+		case domain.CategoryDeleteReparent:
+			// subcategories of a root become roots, but products need a
+			// category to move to
+			if cat.ParentId == 0 {
+				count, err := repos.Catalog.CountCategoryProducts([]uint{id})
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					return domain.ErrorCategoryNotEmpty
+				}
+			}
+			if err := repos.Catalog.ReparentCategories(id, cat.ParentId); err != nil {
+				return err
+			}
+			if err := repos.Catalog.MoveCategoryProducts([]uint{id}, cat.ParentId); err != nil {
+				return err
+			}
+			return repos.Catalog.DeleteCategories([]uint{id})
+
+		case domain.CategoryDeleteCascade:
+			ids := subtreeIds(categories, id)
+			deletedCategoryIds = ids
+			deletedProductIds, err = repos.Catalog.DeleteCategoryProducts(ids)
+			if err != nil {
+				return err
+			}
+			return repos.Catalog.DeleteCategories(ids)
+		}
+
+		if len(subtreeIds(categories, id)) > 1 {
+			return domain.ErrorCategoryNotEmpty
+		}
+		count, err := repos.Catalog.CountCategoryProducts([]uint{id})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return domain.ErrorCategoryNotEmpty
+		}
+
+		return repos.Catalog.DeleteCategories([]uint{id})
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.Si != nil {
+		for _, catId := range deletedCategoryIds {
+			s.Si.Remove(domain.SuggestionCategory, catId)
+		}
+		for _, prodId := range deletedProductIds {
+			s.Si.Remove(domain.SuggestionProduct, prodId)
+		}
+	}
+
+	return nil
 }
 
 func (s CatalogService) EditCategory(id uint, input dto.CreateCategoryDTO) (*domain.Category, error) {
@@ -56,12 +196,17 @@ func (s CatalogService) EditCategory(id uint, input dto.CreateCategoryDTO) (*dom
 		return nil, err
 	}
 
+	// parent changes go through the cycle check
+	if input.ParentId > 0 && input.ParentId != exCat.ParentId {
+		exCat, err = s.MoveCategory(id, dto.MoveCategoryDTO{ParentId: input.ParentId})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(input.Name) > 0 {
 		exCat.Name = input.Name
 	}
-	if input.ParentId > 0 {
-		exCat.ParentId = input.ParentId
-	}
 	if len(input.ImageUrl) > 0 {
 		exCat.ImageUrl = input.ImageUrl
 	}
@@ -74,5 +219,122 @@ func (s CatalogService) EditCategory(id uint, input dto.CreateCategoryDTO) (*dom
 		return nil, err
 	}
 
+	if s.Si != nil {
+		s.Si.Upsert(suggest.Entry{
+			Kind: domain.SuggestionCategory,
+			ID:   updatedCat.ID,
+			Text: updatedCat.Name,
+		})
+	}
+
 	return updatedCat, nil
 }
+
+// MoveCategory puts a category under a new parent, or at the root when
+// ParentId is 0. A category can't be moved below itself.
+func (s CatalogService) MoveCategory(id uint, input dto.MoveCategoryDTO) (*domain.Category, error) {
+
+	var moved *domain.Category
+
+	err := s.Uow.Do(func(repos repository.Repositories) error {
+
+		categories, err := repos.Catalog.LockCategories()
+		if err != nil {
+			return err
+		}
+
+		byId := categoriesById(categories)
+		cat, ok := byId[id]
+		if !ok {
+			return domain.ErrorCategoryNotFound
+		}
+
+		if input.ParentId > 0 {
+			if _, ok := byId[input.ParentId]; !ok {
+				return domain.ErrorParentCategoryNotFound
+			}
+			for _, ancestor := range ancestorsOf(byId, input.ParentId) {
+				if ancestor.ID == id {
+					return domain.ErrorCategoryCycle
+				}
+			}
+		}
+
+		cat.ParentId = input.ParentId
+		if input.DisplayOrder != nil {
+			cat.DisplayOrder = *input.DisplayOrder
+		}
+
+		moved, err = repos.Catalog.EditCategory(cat)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return moved, nil
+}
+
+func categoriesById(categories []*domain.Category) map[uint]*domain.Category {
+	byId := make(map[uint]*domain.Category, len(categories))
+	for _, cat := range categories {
+		byId[cat.ID] = cat
+	}
+	return byId
+}
+
+// ancestorsOf returns id and its parents up to the root. It stops at a
+// missing parent or a category seen before, so bad rows can't loop forever.
+func ancestorsOf(byId map[uint]*domain.Category, id uint) []*domain.Category {
+
+	var ancestors []*domain.Category
+	seen := map[uint]bool{}
+
+	for cat, ok := byId[id]; ok && !seen[cat.ID]; cat, ok = byId[cat.ParentId] {
+		seen[cat.ID] = true
+		ancestors = append(ancestors, cat)
+	}
+
+	return ancestors
+}
+
+// subtreeIds returns id and the ids of all its subcategories.
+func subtreeIds(categories []*domain.Category, id uint) []uint {
+
+	children := map[uint][]uint{}
+	for _, cat := range categories {
+		children[cat.ParentId] = append(children[cat.ParentId], cat.ID)
+	}
+
+	ids := []uint{id}
+	seen := map[uint]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+
+	return ids
+}
+
+// buildCategoryTree nests categories under their parents. categories must be
+// sorted by DisplayOrder, categories whose parent is missing become roots.
+func buildCategoryTree(categories []*domain.Category) []*domain.Category {
+
+	byId := categoriesById(categories)
+
+	roots := []*domain.Category{}
+	for _, cat := range categories {
+		parent, ok := byId[cat.ParentId]
+		if cat.ParentId == 0 || !ok || parent.ID == cat.ID {
+			roots = append(roots, cat)
+			continue
+		}
+		parent.Children = append(parent.Children, cat)
+	}
+
+	return roots
+}
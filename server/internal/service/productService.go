@@ -1,21 +1,35 @@
 package service
 
 import (
+	"context"
 	"ecommerce/config"
 	"ecommerce/internal/domain"
 	"ecommerce/internal/dto"
 	"ecommerce/internal/helper"
 	"ecommerce/internal/repository"
+	"ecommerce/pkg/money"
+	"ecommerce/pkg/suggest"
+	"log"
+	"strings"
+	"time"
 )
 
 type ProductService struct {
 	Repo repository.ProductRepository
+	CRepo repository.CatalogRepository
+	SRepo repository.SearchRepository
+	// Si is the autocomplete index shared by all requests.
+	Si *suggest.Index
 	Auth	helper.Auth
 	Config config.AppConfig
 }
 
 func (s ProductService) CreateProduct (input dto.CreateProductRequest, user domain.User) (*domain.Product,error) {
-	return s.Repo.CreateProduct(&domain.Product{
+	if !input.Price.IsPositive() {
+		return nil, domain.ErrorInvalidPrice
+	}
+
+	prod, err := s.Repo.CreateProduct(&domain.Product{
 		Name: input.Name,
 		Description: input.Description,
 		Price: input.Price,
@@ -24,6 +38,12 @@ func (s ProductService) CreateProduct (input dto.CreateProductRequest, user doma
 		UserId: user.ID,
 		Stock: uint(input.Stock),
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexProduct(prod)
+	return prod, nil
 }
 
 func (s ProductService) EditProduct (id uint,input dto.CreateProductRequest, user domain.User) (*domain.Product,error) {
@@ -46,7 +66,7 @@ func (s ProductService) EditProduct (id uint,input dto.CreateProductRequest, use
 	if len(input.ImageUrl) > 0 {
 		currProd.ImageUrl = input.ImageUrl
 	}
-	if input.Price > 0 {
+	if input.Price.IsPositive() {
 		currProd.Price = input.Price
 	}
 	if input.CategoryId > 0 {
@@ -56,7 +76,13 @@ func (s ProductService) EditProduct (id uint,input dto.CreateProductRequest, use
 		currProd.Stock = uint(input.Stock)
 	}
 
-	return s.Repo.EditProduct(currProd)
+	prod, err := s.Repo.EditProduct(currProd)
+	if err != nil {
+		return nil, err
+	}
+
+	s.indexProduct(prod)
+	return prod, nil
 
 }
 
@@ -71,22 +97,138 @@ func (s ProductService) DeleteProduct(id uint, user domain.User) error {
 		return  helper.NOT_AUTHORIZED_ERROR
 	}
 
-	return s.Repo.DeleteProduct(id);
+	err = s.Repo.DeleteProduct(id)
+	if err != nil {
+		return err
+	}
+
+	if s.Si != nil {
+		s.Si.Remove(domain.SuggestionProduct, id)
+	}
+	return nil
 	
 } 
 
-func (s ProductService) GetProducts()([]*domain.Product, error) {
+// GetProducts returns a page of the product listing. A category filter
+// includes the products of all its subcategories.
+func (s ProductService) GetProducts(filter dto.ProductFilter) ([]*domain.Product, dto.Pagination, error) {
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 {
+		filter.Limit = 20
+	}
+	// larger pages are capped, the pagination tells the client the limit used
+	if filter.Limit > 100 {
+		filter.Limit = 100
+	}
+
+	if filter.CategoryId > 0 {
+		categories, err := s.CRepo.FindCategories()
+		if err != nil {
+			return nil, dto.Pagination{}, err
+		}
+		filter.CategoryIds = subtreeIds(categories, filter.CategoryId)
+	}
+
+	if filter.MinPrice != "" {
+		from, err := money.Parse(filter.MinPrice, filter.Currency)
+		if err != nil {
+			return nil, dto.Pagination{}, err
+		}
+		filter.PriceFrom = &from
+	}
+	if filter.MaxPrice != "" {
+		to, err := money.Parse(filter.MaxPrice, filter.Currency)
+		if err != nil {
+			return nil, dto.Pagination{}, err
+		}
+		filter.PriceTo = &to
+	}
+
+	prods, total, err := s.Repo.FindProducts(filter)
+	if err != nil {
+		return nil, dto.Pagination{}, err
+	}
 
-	return s.Repo.GetProducts();
+	return prods, dto.Pagination{Page: filter.Page, Limit: filter.Limit, Total: total}, s.withAvailableStock(prods...)
+
+}
+
+// SearchProducts returns a page of the products matching query, most
+// relevant first.
+func (s ProductService) SearchProducts(query dto.ProductSearchQuery) ([]domain.ProductSearchHit, dto.Pagination, error) {
+
+	query.Query = strings.TrimSpace(query.Query)
+	if query.Query == "" {
+		return nil, dto.Pagination{}, domain.ErrorEmptySearchQuery
+	}
+
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.Limit < 1 {
+		query.Limit = 20
+	}
+	if query.Limit > 100 {
+		query.Limit = 100
+	}
+
+	hits, total, err := s.SRepo.SearchProducts(query)
+	if err != nil {
+		return nil, dto.Pagination{}, err
+	}
+
+	prods := make([]*domain.Product, 0, len(hits))
+	for i := range hits {
+		prods = append(prods, &hits[i].Product)
+	}
+
+	return hits, dto.Pagination{Page: query.Page, Limit: query.Limit, Total: total}, s.withAvailableStock(prods...)
 
 }
 
 func (s ProductService) GetProductById(id uint) (*domain.Product, error) {
 
-	return s.Repo.GetProductById(id);
+	prod, err := s.Repo.GetProductById(id);
+	if err != nil {
+		return nil, err
+	}
+
+	return prod, s.withAvailableStock(prod)
 
 }
 
+// withAvailableStock replaces the stock of prods with what buyers can still
+// purchase, i.e. stock minus units held for open payment sessions.
+func (s ProductService) withAvailableStock(prods ...*domain.Product) error {
+
+	if len(prods) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(prods))
+	for _, prod := range prods {
+		ids = append(ids, prod.ID)
+	}
+
+	reserved, err := s.Repo.ReservedStock(ids, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, prod := range prods {
+		if reserved[prod.ID] >= prod.Stock {
+			prod.Stock = 0
+		} else {
+			prod.Stock -= reserved[prod.ID]
+		}
+	}
+
+	return nil
+}
+
 func (s ProductService) GetSellerProducts(sellerId uint) ([]*domain.Product, error) {
 	return s.Repo.FindSellerProducts(sellerId)
 }
@@ -108,4 +250,97 @@ func (s ProductService) UpdateProductStock(id uint, stock int, user domain.User)
 	}
 
 	return updatedProd, nil
-}
\ No newline at end of file
+}
+// SuggestProducts returns product and category names starting with query for
+// type-ahead, most ordered first.
+func (s ProductService) SuggestProducts(query string, limit int) []domain.Suggestion {
+
+	if limit < 1 || limit > 20 {
+		limit = 8
+	}
+
+	if s.Si == nil {
+		return []domain.Suggestion{}
+	}
+
+	entries := s.Si.Suggest(query, limit)
+
+	suggestions := make([]domain.Suggestion, 0, len(entries))
+	for _, e := range entries {
+		suggestions = append(suggestions, domain.Suggestion{
+			Kind:   e.Kind,
+			ID:     e.ID,
+			Text:   e.Text,
+			Weight: e.Weight,
+		})
+	}
+
+	return suggestions
+}
+
+// RefreshSuggestions reloads the autocomplete index with current names and
+// order counts.
+func (s ProductService) RefreshSuggestions() error {
+
+	if s.Si == nil {
+		return nil
+	}
+
+	suggestions, err := s.SRepo.FindSuggestions()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]suggest.Entry, 0, len(suggestions))
+	for _, sg := range suggestions {
+		entries = append(entries, suggest.Entry{
+			Kind:   sg.Kind,
+			ID:     sg.ID,
+			Text:   sg.Text,
+			Weight: sg.Weight,
+		})
+	}
+
+	s.Si.Replace(entries)
+	return nil
+}
+
+// RunSuggestionRefresher loads the autocomplete index and reloads it every
+// interval until ctx is done, which picks up new orders. Product and category
+// changes are applied straight away. It blocks, so start it in its own
+// goroutine.
+func (s ProductService) RunSuggestionRefresher(ctx context.Context, interval time.Duration) {
+
+	if err := s.RefreshSuggestions(); err != nil {
+		log.Printf("error loading suggestions: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RefreshSuggestions(); err != nil {
+				log.Printf("error refreshing suggestions: %v", err)
+			}
+		}
+	}
+}
+
+// indexProduct updates the product in the autocomplete index, keeping its
+// popularity.
+func (s ProductService) indexProduct(prod *domain.Product) {
+
+	if s.Si == nil {
+		return
+	}
+
+	s.Si.Upsert(suggest.Entry{
+		Kind: domain.SuggestionProduct,
+		ID:   prod.ID,
+		Text: prod.Name,
+	})
+}
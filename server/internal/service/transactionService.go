@@ -1,17 +1,25 @@
 package service
 
 import (
+	"context"
 	"ecommerce/config"
 	"ecommerce/internal/domain"
 	"ecommerce/internal/dto"
 	"ecommerce/internal/helper"
 	"ecommerce/internal/repository"
+	"ecommerce/pkg/money"
+	"ecommerce/pkg/payment"
+	"errors"
+	"log"
+	"time"
 )
 
 type TransactionService struct {
 	Auth   helper.Auth
 	Config config.AppConfig
 	Repo   repository.TransactionRepository
+	PRepo  repository.ProductRepository
+	Pc     payment.PaymentClient
 }
 
 func (s TransactionService) GetActivePayments(uId uint) (*domain.Payment, error) {
@@ -32,31 +40,451 @@ func (s TransactionService) StoreCreatedPayment(payload dto.CreatePaymentRequest
 	return s.Repo.CreatePayment(&payment)
 }
 
-func (s TransactionService) UpdatePayment(userId uint, status string, paymentLog string) error {
-	p, err := s.GetActivePayments(userId)
+func (s TransactionService) GetLatestPayment(uId uint) (*domain.Payment, error) {
+	return s.Repo.FindLatestPayment(uId)
+}
+
+func (s TransactionService) GetPaymentByPaymentId(pId string) (*domain.Payment, error) {
+	return s.Repo.FindPaymentByPaymentId(pId)
+}
+
+// UpdatePayment moves the payment to status. Updates that would move a payment
+// backwards, e.g. a late failure after success, are ignored.
+func (s TransactionService) UpdatePayment(p *domain.Payment, status domain.PaymentStatus, paymentLog string) error {
+	return s.Repo.UpdatePaymentStatus(p, status, paymentLog)
+}
+
+// ReleaseReservations returns the stock held for a payment session.
+func (s TransactionService) ReleaseReservations(orderRef string) error {
+	return s.PRepo.UpdateReservations(orderRef, domain.ReservationStatusActive, domain.ReservationStatusReleased)
+}
+
+// ReleaseExpiredReservations releases reservations whose payment session ran
+// out. The open payment is cancelled first so the buyer can't pay for stock
+// that is no longer held; if that fails the payment may already have gone
+// through and the reservation is left for the order to convert.
+func (s TransactionService) ReleaseExpiredReservations() error {
+
+	refs, err := s.PRepo.FindExpiredReservationRefs(time.Now())
 	if err != nil {
 		return err
 	}
 
-	p.Status = domain.PaymentStatus(status)
-	p.Response = paymentLog
+	for _, ref := range refs {
+
+		p, err := s.Repo.FindPaymentByOrderRef(ref)
+		if err == nil && p.Status == domain.PaymentStatusInitial {
+			if _, err := s.Pc.CancelPayment(p.PaymentId); err != nil {
+				log.Printf("keeping expired reservation %s, payment not cancelled: %v", ref, err)
+				continue
+			}
+			if err := s.UpdatePayment(p, domain.PaymentStatusFailed, "stock reservation expired"); err != nil {
+				return err
+			}
+		}
+
+		if err := s.ReleaseReservations(ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunReservationSweeper releases expired reservations every interval until
+// ctx is done. It blocks, so start it in its own goroutine.
+func (s TransactionService) RunReservationSweeper(ctx context.Context, interval time.Duration) {
 
-	return s.Repo.UpdatePayment(p)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ReleaseExpiredReservations(); err != nil {
+				log.Printf("error releasing expired stock reservations: %v", err)
+			}
+		}
+	}
 }
 
-func (s TransactionService) GetOrders(u uint) ([]domain.Order, error) {
-	orders, err := s.Repo.FindOrders(u)
+// RefundUnfulfilledPayment gives the money back for a payment that succeeded
+// but could not be turned into an order, e.g. because the stock ran out.
+func (s TransactionService) RefundUnfulfilledPayment(p *domain.Payment, paymentLog string) error {
+
+	// verification and the webhook may both get here for the same payment
+	current, err := s.Repo.FindPaymentByPaymentId(p.PaymentId)
+	if err == nil && current.Status == domain.PaymentStatusRefunded {
+		return nil
+	}
+
+	_, err = s.Pc.RefundPayment(p.PaymentId, money.Money{}, "unfulfilled_"+p.PaymentId)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	err = s.UpdatePayment(p, domain.PaymensStatusSuccess, paymentLog)
+	if err != nil {
+		return err
+	}
+
+	return s.UpdatePayment(p, domain.PaymentStatusRefunded, paymentLog)
+}
+
+// RefundPayment marks a fully refunded payment and its order as refunded.
+// Refunds made on the gateway directly, e.g. from its dashboard, have no
+// Refund records yet, so the items left on the order are recorded and
+// restocked here.
+func (s TransactionService) RefundPayment(p *domain.Payment, paymentLog string) error {
+
+	err := s.UpdatePayment(p, domain.PaymentStatusRefunded, paymentLog)
+	if err != nil {
+		return err
+	}
+
+	order, err := s.Repo.FindOrderByRef(p.OrderId)
+	if err != nil {
+		if errors.Is(err, domain.ErrorOrderNotFound) {
+			return nil
+		}
+		return err
+	}
+	// cancelling a paid order refunds it, there is nothing left to move
+	if order.Status == domain.OrderStatusRefunded || order.Status == domain.OrderStatusCancelled {
+		return nil
+	}
+
+	// a refund issued through RefundOrder is still being completed, it moves
+	// the order itself
+	for _, refund := range order.Refunds {
+		if refund.Status == domain.RefundStatusPending {
+			return nil
+		}
 	}
-	return orders, nil
+
+	refunds, err := refundLines(order, dto.RefundRequest{Reason: "refunded through payment gateway"})
+	if errors.Is(err, domain.ErrorNothingToRefund) {
+		_, err = s.UpdateOrderStatus(order.OrderRef, domain.OrderStatusRefunded, domain.User{}, domain.SYSTEM, "refunded through payment gateway")
+		if errors.Is(err, domain.ErrorInvalidOrderTransition) {
+			log.Printf("order %s not moved to refunded: %v", order.OrderRef, err)
+			return nil
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	for i := range refunds {
+		refunds[i].PaymentId = p.ID
+		refunds[i].IdempotencyKey = "gateway_" + p.PaymentId
+		refunds[i].InitiatorRole = domain.SYSTEM
+	}
+
+	err = s.Repo.ReserveRefunds(refunds)
+	if err != nil {
+		return err
+	}
+
+	return s.completeRefunds(order.OrderRef, refunds, "", p, domain.User{}, domain.SYSTEM, "refunded through payment gateway", domain.OrderStatusRefunded)
 }
 
-func (s TransactionService) GetOrderById(orderId, sellerId uint) (dto.SellerOrderDetails, error) {
-	order, err := s.Repo.FindOrderById(orderId, sellerId)
+func (s TransactionService) GetOrders(sellerId uint, filter dto.SellerOrderFilter) ([]dto.SellerOrderDetails, dto.Pagination, error) {
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 {
+		filter.Limit = 20
+	}
+	if filter.Limit > 100 {
+		filter.Limit = 100
+	}
+
+	orders, total, err := s.Repo.FindOrders(sellerId, filter)
+	if err != nil {
+		return nil, dto.Pagination{}, err
+	}
+
+	return orders, dto.Pagination{Page: filter.Page, Limit: filter.Limit, Total: total}, nil
+}
+
+func (s TransactionService) GetOrderById(orderItemId, sellerId uint) (dto.SellerOrderDetails, error) {
+	order, err := s.Repo.FindOrderById(orderItemId, sellerId)
 	if err != nil {
 		return dto.SellerOrderDetails{}, err
 	}
 	return order, nil
 }
+
+// UpdateOrderStatus moves the order identified by orderRef to next on behalf of
+// actor, acting as actorRole. Buyers may only act on their own orders and
+// sellers only on orders whose items are all theirs; admins fulfil the orders
+// that span sellers. Cancelling an order that
+// has been paid refunds and restocks whatever is left on it.
+func (s TransactionService) UpdateOrderStatus(orderRef string, next domain.OrderStatus, actor domain.User, actorRole string, note string) (*domain.Order, error) {
+
+	if !next.IsValid() {
+		return nil, domain.ErrorInvalidOrderStatus
+	}
+
+	order, err := s.Repo.FindOrderByRef(orderRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canActOnOrder(order, actor, actorRole) {
+		return nil, domain.ErrorOrderNotFound
+	}
+
+	from := order.FulfilmentStatus()
+
+	if !from.CanTransitionTo(next) {
+		return nil, domain.OrderTransitionError{From: order.Status, To: next}
+	}
+
+	if !from.CanBeMovedBy(actorRole, next) {
+		return nil, helper.NOT_AUTHORIZED_ERROR
+	}
+
+	if next == domain.OrderStatusCancelled && from.IsPaid() {
+		refunds, err := refundLines(order, dto.RefundRequest{Reason: note})
+		if err == nil {
+			return s.issueRefunds(order, refunds, actor, actorRole, note, domain.OrderStatusCancelled)
+		}
+		if !errors.Is(err, domain.ErrorNothingToRefund) {
+			return nil, err
+		}
+	}
+
+	err = s.Repo.UpdateOrderStatus(order, domain.OrderStatusHistory{
+		FromStatus: order.Status,
+		ToStatus:   next,
+		ActorId:    actor.ID,
+		ActorRole:  actorRole,
+		Note:       note,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func canActOnOrder(order *domain.Order, actor domain.User, actorRole string) bool {
+
+	switch actorRole {
+	case domain.BUYER:
+		return order.UserId == actor.ID
+	case domain.SELLER:
+		// a status change affects the whole order, so it must not touch
+		// items of other sellers
+		for _, item := range order.Items {
+			if item.SellerId != actor.ID {
+				return false
+			}
+		}
+		return len(order.Items) > 0
+	case domain.ADMIN, domain.SYSTEM:
+		return true
+	}
+
+	return false
+}
+
+// RefundOrder refunds the requested order items through the payment gateway,
+// restocks them and moves the payment and order along. Only admins refund
+// orders, so any item of the order may be refunded.
+func (s TransactionService) RefundOrder(orderRef string, input dto.RefundRequest, actor domain.User) (*domain.Order, error) {
+
+	order, err := s.Repo.FindOrderByRef(orderRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if !order.FulfilmentStatus().CanTransitionTo(domain.OrderStatusRefunded) {
+		return nil, domain.OrderTransitionError{From: order.Status, To: domain.OrderStatusRefunded}
+	}
+
+	refunds, err := refundLines(order, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueRefunds(order, refunds, actor, domain.ADMIN, input.Reason, domain.OrderStatusRefunded)
+}
+
+// issueRefunds refunds the given lines of order through the payment gateway
+// and restocks them. Once nothing is left to refund the order moves to
+// finalStatus, until then it is partially refunded.
+func (s TransactionService) issueRefunds(order *domain.Order, refunds []domain.Refund, actor domain.User, actorRole string, note string, finalStatus domain.OrderStatus) (*domain.Order, error) {
+
+	orderRef := order.OrderRef
+
+	p, err := s.Repo.FindPaymentByPaymentId(order.PaymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := helper.RandomString(16)
+	if err != nil {
+		return nil, err
+	}
+	key = "refund_" + order.OrderRef + "_" + key
+
+	var amount money.Money
+	for i := range refunds {
+		amount, err = amount.Add(refunds[i].Amount)
+		if err != nil {
+			return nil, err
+		}
+		refunds[i].PaymentId = p.ID
+		refunds[i].IdempotencyKey = key
+		refunds[i].InitiatedBy = actor.ID
+		refunds[i].InitiatorRole = actorRole
+	}
+
+	// the quantities are reserved before the gateway is called so that a
+	// repeated or concurrent request cannot refund the same items again
+	err = s.Repo.ReserveRefunds(refunds)
+	if err != nil {
+		return nil, err
+	}
+
+	gatewayRefund, err := s.Pc.RefundPayment(p.PaymentId, amount, key)
+	if err != nil {
+		if releaseErr := s.Repo.ReleaseRefunds(refunds); releaseErr != nil {
+			log.Printf("refund %s for order %s failed and was not released: %v", key, order.OrderRef, releaseErr)
+		}
+		return nil, err
+	}
+
+	err = s.completeRefunds(orderRef, refunds, gatewayRefund.ID, p, actor, actorRole, note, finalStatus)
+	if err != nil {
+		log.Printf("refund %s issued for order %s but left pending: %v", gatewayRefund.ID, orderRef, err)
+		return nil, err
+	}
+
+	return s.Repo.FindOrderByRef(orderRef)
+}
+
+// completeRefunds records refunds the gateway has made, restocks them and
+// moves the payment along. The order becomes partially refunded, or moves to
+// finalStatus once no item has anything left to refund, unless it can no
+// longer get there, e.g. because the gateway webhook got there first. The order is read again on every attempt
+// since it may have moved on while the gateway was called; the money has
+// moved already, so a concurrent status change must not undo the record.
+func (s TransactionService) completeRefunds(orderRef string, refunds []domain.Refund, gatewayRefundId string, p *domain.Payment, actor domain.User, actorRole string, note string, finalStatus domain.OrderStatus) error {
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+
+		var order *domain.Order
+		order, err = s.Repo.FindOrderByRef(orderRef)
+		if err != nil {
+			return err
+		}
+
+		fullyRefunded := true
+		for _, item := range order.Items {
+			if item.RefundedQty < item.Qty {
+				fullyRefunded = false
+				break
+			}
+		}
+
+		paymentStatus := domain.PaymentStatusPartiallyRefunded
+		next := domain.OrderStatusPartiallyRefunded
+		if fullyRefunded {
+			paymentStatus = domain.PaymentStatusRefunded
+			next = finalStatus
+		}
+
+		var history *domain.OrderStatusHistory
+		if order.FulfilmentStatus().CanTransitionTo(next) {
+			history = &domain.OrderStatusHistory{
+				FromStatus: order.Status,
+				ToStatus:   next,
+				ActorId:    actor.ID,
+				ActorRole:  actorRole,
+				Note:       note,
+			}
+		} else {
+			log.Printf("order %s refunded but left %s instead of %s", orderRef, order.Status, next)
+		}
+
+		err = s.Repo.CompleteRefunds(refunds, gatewayRefundId, p, paymentStatus, order, history)
+		if !errors.Is(err, domain.ErrorInvalidOrderTransition) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// refundLines resolves the refund request against the order items.
+func refundLines(order *domain.Order, input dto.RefundRequest) ([]domain.Refund, error) {
+
+	refundable := map[uint]domain.OrderItem{}
+	for _, item := range order.Items {
+		refundable[item.ID] = item
+	}
+
+	requested := input.Items
+	if len(requested) == 0 {
+		for _, item := range order.Items {
+			requested = append(requested, dto.RefundItemInput{OrderItemId: item.ID})
+		}
+	}
+
+	// the same item may be listed more than once, its quantities add up
+	var itemIds []uint
+	quantities := map[uint]uint{}
+	for _, line := range requested {
+
+		item, ok := refundable[line.OrderItemId]
+		if !ok {
+			return nil, domain.ErrorOrderItemNotFound
+		}
+
+		qty := line.Qty
+		if qty == 0 {
+			qty = item.Qty - item.RefundedQty
+		}
+
+		if _, ok := quantities[item.ID]; !ok {
+			itemIds = append(itemIds, item.ID)
+		}
+		quantities[item.ID] += qty
+	}
+
+	var refunds []domain.Refund
+	for _, id := range itemIds {
+
+		item := refundable[id]
+		qty := quantities[id]
+
+		if qty > item.Qty-item.RefundedQty {
+			return nil, domain.ErrorRefundExceedsQuantity
+		}
+		if qty == 0 {
+			continue
+		}
+
+		refunds = append(refunds, domain.Refund{
+			OrderId:     order.ID,
+			OrderItemId: item.ID,
+			ProductId:   item.ProductId,
+			Qty:         qty,
+			Amount:      item.Price.Mul(qty),
+			Reason:      input.Reason,
+		})
+	}
+
+	if len(refunds) == 0 {
+		return nil, domain.ErrorNothingToRefund
+	}
+
+	return refunds, nil
+}
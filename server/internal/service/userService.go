@@ -1,30 +1,48 @@
 package service
 
 import (
+	"crypto/rand"
 	"ecommerce/config"
 	"ecommerce/internal/domain"
 	"ecommerce/internal/dto"
 	"ecommerce/internal/helper"
 	"ecommerce/internal/repository"
+	"ecommerce/pkg/money"
 	"ecommerce/pkg/notification"
+	"ecommerce/pkg/payment"
+	"ecommerce/pkg/totp"
+	"encoding/base32"
 	"errors"
 	"fmt"
 	"log"
+	"net/mail"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
+// totpIssuer names the account in authenticator apps.
+const totpIssuer = "ecommerce"
+
 type UserService struct {
 	Repo   repository.UserRepository
 	PRepo  repository.ProductRepository
+	TRepo  repository.TransactionRepository
+	SRepo  repository.SessionRepository
+	ThRepo repository.ThrottleRepository
+	Uow    repository.UnitOfWork
+	Pc     payment.PaymentClient
+	Nc     notification.NotificationClient
 	Auth   helper.Auth
 	Config config.AppConfig
 }
 
-func (s UserService) SignUp(input dto.UserSignUp) (string, error) {
+func (s UserService) SignUp(input dto.UserSignUp) (*dto.AuthTokens, error) {
 
 	hPassword, err := s.Auth.CreateHashedPassword(input.Password)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	user, err := s.Repo.CreateUser(domain.User{
@@ -34,10 +52,10 @@ func (s UserService) SignUp(input dto.UserSignUp) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return s.Auth.GenerateToken(user.ID, user.Email, user.UserType)
+	return s.startSession(user, false)
 
 }
 
@@ -49,20 +67,764 @@ func (s UserService) findUserByEmail(email string) (*domain.User, error) {
 
 }
 
-func (s UserService) Login(email string, password string) (string, error) {
-
-	user, err := s.findUserByEmail(email)
-	if err != nil {
-		return "", errors.New("user with given credentials doesn't exists")
-	}
+// Login counts failures per account and per client ip, both lock out for
+// exponentially longer once their limit is reached. Accounts with two factor
+// authentication get a challenge to complete with LoginTwoFactor instead of
+// tokens.
+func (s UserService) Login(email string, password string, ip string) (*dto.AuthTokens, *dto.TwoFactorChallenge, error) {
+
+	accountKey := "login:account:" + strings.ToLower(email)
+	ipKey := "login:ip:" + ip
+
+	if err := s.checkThrottles(accountKey, ipKey); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.findUserByEmail(email)
+	if err != nil {
+		s.registerAttempt(accountKey, domain.LoginAccountThrottle)
+		s.registerAttempt(ipKey, domain.LoginIpThrottle)
+		return nil, nil, errors.New("user with given credentials doesn't exists")
+	}
+
+	err = s.Auth.VerifyPassword(password, user.Password)
+	if err != nil {
+		s.registerAttempt(accountKey, domain.LoginAccountThrottle)
+		s.registerAttempt(ipKey, domain.LoginIpThrottle)
+		return nil, nil, err
+	}
+
+	// the ip counter only decays, one known password must not clear it
+	s.resetThrottle(accountKey)
+
+	if user.Suspended {
+		return nil, nil, domain.ErrorUserSuspended
+	}
+
+	if user.TwoFactorEnabled {
+		challenge, err := s.Auth.GenerateChallengeToken(user.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &dto.TwoFactorChallenge{
+			ChallengeToken: challenge,
+			ExpiresIn:      int64(domain.TwoFactorChallengeTTL.Seconds()),
+		}, nil
+	}
+
+	tokens, err := s.startSession(*user, false)
+	return tokens, nil, err
+
+}
+
+// LoginTwoFactor completes a login challenge with an authenticator or
+// recovery code.
+func (s UserService) LoginTwoFactor(challengeToken string, code string) (*dto.AuthTokens, error) {
+
+	id, err := s.Auth.VerifyChallengeToken(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Repo.FindUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Suspended {
+		return nil, domain.ErrorUserSuspended
+	}
+	if !user.TwoFactorEnabled {
+		return nil, domain.ErrorTwoFactorNotEnabled
+	}
+
+	if err := s.verifySecondFactor(user, code); err != nil {
+		return nil, err
+	}
+
+	return s.startSession(user, true)
+}
+
+// EnrollTwoFactor generates a new authenticator secret. It takes effect once
+// a code of it is confirmed with ConfirmTwoFactor.
+func (s UserService) EnrollTwoFactor(id uint) (*dto.TwoFactorEnrollment, error) {
+
+	user, err := s.Repo.FindUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, domain.ErrorTwoFactorAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// the secret is stored encrypted so a database read doesn't expose it
+	encrypted, err := s.Auth.EncryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Repo.UpdateTwoFactor(id, encrypted, false); err != nil {
+		return nil, err
+	}
+
+	return &dto.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningUri: totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two factor authentication with a code of the
+// enrolled secret and issues recovery codes. Other sessions are revoked and
+// the caller continues in a new two factor session.
+func (s UserService) ConfirmTwoFactor(id uint, code string) (*dto.TwoFactorConfirmation, error) {
+
+	user, err := s.Repo.FindUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabled {
+		return nil, domain.ErrorTwoFactorAlreadyEnabled
+	}
+	if user.TotpSecret == "" {
+		return nil, domain.ErrorTwoFactorNotEnrolled
+	}
+
+	codeKey := fmt.Sprintf("2fa:account:%d", id)
+	if err := s.checkThrottles(codeKey); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.Auth.DecryptSecret(user.TotpSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		s.registerAttempt(codeKey, domain.LoginAccountThrottle)
+		return nil, domain.ErrorInvalidTwoFactorCode
+	}
+
+	recoveryCodes, hashed, err := s.newRecoveryCodes(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Uow.Do(func(repos repository.Repositories) error {
+
+		if err := repos.User.UpdateTwoFactor(id, user.TotpSecret, true); err != nil {
+			return err
+		}
+
+		if err := repos.User.UpdateTotpLastStep(id, step); err != nil {
+			return err
+		}
+
+		if err := repos.User.ReplaceRecoveryCodes(id, hashed); err != nil {
+			return err
+		}
+
+		return repos.Session.RevokeUserSessions(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.startSession(user, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.TwoFactorConfirmation{AuthTokens: *tokens, RecoveryCodes: recoveryCodes}, nil
+}
+
+// DisableTwoFactor turns two factor authentication off after checking the
+// password and a second factor. Sellers can't when it is required for them.
+func (s UserService) DisableTwoFactor(id uint, input dto.DisableTwoFactorInput) error {
+
+	user, err := s.Repo.FindUserById(id)
+	if err != nil {
+		return err
+	}
+	if !user.TwoFactorEnabled {
+		return domain.ErrorTwoFactorNotEnabled
+	}
+	if user.UserType == domain.SELLER && s.Config.RequireSellerTwoFactor {
+		return domain.ErrorTwoFactorRequired
+	}
+
+	if err := s.verifyCurrentPassword(user, input.Password); err != nil {
+		return err
+	}
+
+	if err := s.verifySecondFactor(user, input.Code); err != nil {
+		return err
+	}
+
+	return s.Uow.Do(func(repos repository.Repositories) error {
+
+		if err := repos.User.UpdateTwoFactor(id, "", false); err != nil {
+			return err
+		}
+
+		return repos.User.ReplaceRecoveryCodes(id, nil)
+	})
+}
+
+// verifySecondFactor accepts a current authenticator code that wasn't used
+// yet or an unused recovery code. Failures count towards a lockout.
+func (s UserService) verifySecondFactor(user domain.User, code string) error {
+
+	codeKey := fmt.Sprintf("2fa:account:%d", user.ID)
+	if err := s.checkThrottles(codeKey); err != nil {
+		return err
+	}
+
+	secret, err := s.Auth.DecryptSecret(user.TotpSecret)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		err = s.Repo.UpdateTotpLastStep(user.ID, step)
+	} else {
+		err = s.Repo.UseRecoveryCode(user.ID, s.Auth.HashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err != nil {
+		if errors.Is(err, domain.ErrorInvalidTwoFactorCode) {
+			s.registerAttempt(codeKey, domain.LoginAccountThrottle)
+		}
+		return err
+	}
+
+	s.resetThrottle(codeKey)
+	return nil
+}
+
+// newRecoveryCodes returns the codes to show the user and their hashed
+// records.
+func (s UserService) newRecoveryCodes(uId uint) ([]string, []domain.RecoveryCode, error) {
+
+	codes := make([]string, 0, domain.RecoveryCodeCount)
+	hashed := make([]domain.RecoveryCode, 0, domain.RecoveryCodeCount)
+
+	for range domain.RecoveryCodeCount {
+		buffer := make([]byte, 5)
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(buffer))
+		code := raw[:4] + "-" + raw[4:]
+
+		codes = append(codes, code)
+		hashed = append(hashed, domain.RecoveryCode{
+			UserId:   uId,
+			CodeHash: s.Auth.HashToken(normalizeRecoveryCode(code)),
+		})
+	}
+
+	return codes, hashed, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// verifyCurrentPassword checks the password of a logged in user. Wrong
+// passwords count against the account like failed logins, so a stolen access
+// token can't be used to guess it.
+func (s UserService) verifyCurrentPassword(user domain.User, password string) error {
+
+	key := fmt.Sprintf("password:user:%d", user.ID)
+
+	if err := s.checkThrottles(key); err != nil {
+		return err
+	}
+
+	if err := s.Auth.VerifyPassword(password, user.Password); err != nil {
+		s.registerAttempt(key, domain.LoginAccountThrottle)
+		return domain.ErrorIncorrectPassword
+	}
+
+	s.resetThrottle(key)
+	return nil
+}
+
+// checkThrottles fails while any of the keys is locked out.
+func (s UserService) checkThrottles(keys ...string) error {
+
+	throttles, err := s.ThRepo.FindThrottles(keys)
+	if err != nil {
+		return err
+	}
+
+	return domain.CheckThrottles(time.Now(), throttles...)
+}
+
+// registerAttempt counts an attempt. A failure to count is logged rather
+// than failing the request.
+func (s UserService) registerAttempt(key string, policy domain.ThrottlePolicy) {
+	if _, err := s.ThRepo.RegisterAttempt(key, policy); err != nil {
+		log.Printf("error counting attempt for %s: %v", key, err)
+	}
+}
+
+func (s UserService) resetThrottle(key string) {
+	if err := s.ThRepo.ResetThrottle(key); err != nil {
+		log.Printf("error resetting attempts for %s: %v", key, err)
+	}
+}
+
+// startSession opens a new login session and issues its first token pair.
+// twoFactor records whether the login passed a second factor.
+func (s UserService) startSession(user domain.User, twoFactor bool) (*dto.AuthTokens, error) {
+
+	sessionId, err := s.Auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, next, err := s.newRefreshToken(sessionId, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.SRepo.CreateSession(domain.Session{ID: sessionId, UserId: user.ID, TwoFactor: twoFactor}, next)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(user, sessionId, refreshToken, twoFactor)
+}
+
+// RefreshSession exchanges a refresh token for a new token pair of the same
+// session. A token that was already exchanged revokes the whole session, as
+// either the buyer or whoever stole the token is replaying it.
+func (s UserService) RefreshSession(refreshToken string) (*dto.AuthTokens, error) {
+
+	if refreshToken == "" {
+		return nil, domain.ErrorInvalidRefreshToken
+	}
+
+	used, err := s.SRepo.FindRefreshToken(s.Auth.HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if used.UsedAt != nil {
+		log.Printf("refresh token reuse detected for session %s", used.SessionId)
+		if err := s.SRepo.RevokeSession(used.SessionId); err != nil {
+			return nil, err
+		}
+		return nil, domain.ErrorRefreshTokenReused
+	}
+
+	if time.Now().After(used.ExpiresAt) {
+		return nil, domain.ErrorInvalidRefreshToken
+	}
+
+	session, err := s.SRepo.FindSession(used.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil {
+		return nil, domain.ErrorSessionRevoked
+	}
+
+	user, err := s.Repo.FindUserById(used.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user.Suspended {
+		return nil, domain.ErrorUserSuspended
+	}
+
+	nextToken, next, err := s.newRefreshToken(used.SessionId, used.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.SRepo.RotateRefreshToken(used, next)
+	if err != nil {
+		if errors.Is(err, domain.ErrorRefreshTokenReused) {
+			log.Printf("refresh token reuse detected for session %s", used.SessionId)
+			if err := s.SRepo.RevokeSession(used.SessionId); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	return s.issueTokens(user, used.SessionId, nextToken, session.TwoFactor)
+}
+
+// Logout revokes the session, which ends its access and refresh tokens.
+func (s UserService) Logout(sessionId string) error {
+	return s.SRepo.RevokeSession(sessionId)
+}
+
+func (s UserService) newRefreshToken(sessionId string, uId uint) (string, domain.RefreshToken, error) {
+
+	token, err := s.Auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", domain.RefreshToken{}, err
+	}
+
+	return token, domain.RefreshToken{
+		SessionId: sessionId,
+		UserId:    uId,
+		TokenHash: s.Auth.HashToken(token),
+		ExpiresAt: time.Now().Add(domain.RefreshTokenTTL),
+	}, nil
+}
+
+func (s UserService) issueTokens(user domain.User, sessionId string, refreshToken string, twoFactor bool) (*dto.AuthTokens, error) {
+
+	token, err := s.Auth.GenerateToken(user.ID, user.Email, user.UserType, sessionId, twoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.AuthTokens{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(domain.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RequestPasswordReset sends a single use reset token to the user over
+// channel, or their default channel when it is empty. It succeeds for unknown
+// emails too so the endpoint doesn't reveal accounts. Requests are counted
+// per email and per client ip like logins, as every one of them may send a
+// message.
+func (s UserService) RequestPasswordReset(email string, channel notification.Channel, ip string) error {
+
+	// checked before the lookup, it doesn't depend on the account
+	if channel != "" && !s.Nc.Supports(channel) {
+		return notification.ErrorChannelUnavailable
+	}
+
+	// keyed by the email as given, so unknown emails are throttled alike
+	accountKey := "reset:account:" + strings.ToLower(email)
+	ipKey := "reset:ip:" + ip
+
+	if err := s.checkThrottles(accountKey, ipKey); err != nil {
+		return err
+	}
+	s.registerAttempt(accountKey, domain.SmsThrottle)
+	s.registerAttempt(ipKey, domain.LoginIpThrottle)
+
+	// the lookup and the send happen after the response so its timing is the
+	// same for known and unknown emails. fiber reuses the request buffer the
+	// email may point into once the handler returns.
+	email = strings.Clone(email)
+	go func() {
+		if err := s.sendPasswordReset(email, channel); err != nil {
+			log.Printf("error creating password reset: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// sendPasswordReset creates and sends the reset token for RequestPasswordReset.
+func (s UserService) sendPasswordReset(email string, channel notification.Channel) error {
+
+	user, err := s.findUserByEmail(email)
+	if err != nil {
+		log.Printf("password reset requested for unknown email")
+		return nil
+	}
+
+	token, err := s.Auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.SRepo.CreatePasswordResetToken(domain.PasswordResetToken{
+		UserId:    user.ID,
+		TokenHash: s.Auth.HashToken(token),
+		ExpiresAt: time.Now().Add(domain.PasswordResetTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your password reset code is %s and is valid for next 30 minutes.", token)
+	if s.Config.PasswordResetUrl != "" {
+		message = fmt.Sprintf("Reset your password at %s?token=%s within the next 30 minutes.", s.Config.PasswordResetUrl, token)
+	}
+
+	err = s.notify(*user, channel, "Reset your password", message)
+	if err != nil {
+		fmt.Printf("error sending password reset to userId : %d, %v\n", user.ID, err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password with a reset token and logs the user
+// out everywhere.
+func (s UserService) ResetPassword(token string, password string) error {
+
+	if token == "" {
+		return domain.ErrorInvalidResetToken
+	}
+
+	reset, err := s.SRepo.FindPasswordResetToken(s.Auth.HashToken(token))
+	if err != nil {
+		return err
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return domain.ErrorInvalidResetToken
+	}
+
+	hPassword, err := s.Auth.CreateHashedPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.Uow.Do(func(repos repository.Repositories) error {
+
+		if err := repos.Session.ConsumePasswordResetToken(reset); err != nil {
+			return err
+		}
+
+		if _, err := repos.User.UpdateUser(reset.UserId, domain.User{Password: hPassword}); err != nil {
+			return err
+		}
+
+		return repos.Session.RevokeUserSessions(reset.UserId)
+	})
+}
+
+// ChangePassword replaces the password after checking the current one. All
+// sessions are revoked and a new one is started for the caller, keeping the
+// second factor state of sessionId.
+func (s UserService) ChangePassword(id uint, sessionId string, input dto.ChangePasswordInput) (*dto.AuthTokens, error) {
+
+	user, err := s.Repo.FindUserById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.SRepo.FindSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyCurrentPassword(user, input.CurrentPassword); err != nil {
+		return nil, err
+	}
+
+	hPassword, err := s.Auth.CreateHashedPassword(input.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Uow.Do(func(repos repository.Repositories) error {
+
+		if _, err := repos.User.UpdateUser(id, domain.User{Password: hPassword}); err != nil {
+			return err
+		}
+
+		return repos.Session.RevokeUserSessions(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.startSession(user, session.TwoFactor)
+}
+
+// ChangeEmail moves the account to a new email after checking the password.
+// The account has to be verified again and, as the email is part of the
+// token claims, new tokens are issued.
+func (s UserService) ChangeEmail(id uint, sessionId string, input dto.ChangeEmailInput) (*dto.AuthTokens, error) {
+
+	if !isPlainEmail(input.Email) {
+		return nil, domain.ErrorInvalidEmail
+	}
+
+	user, err := s.Repo.FindUserById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyCurrentPassword(user, input.CurrentPassword); err != nil {
+		return nil, err
+	}
+
+	session, err := s.SRepo.FindSession(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Email == user.Email {
+		return nil, domain.ErrorEmailAlreadyInUse
+	}
+	if existing, err := s.Repo.FindUser(input.Email); err == nil && existing.ID > 0 {
+		return nil, domain.ErrorEmailAlreadyInUse
+	}
+
+	err = s.Uow.Do(func(repos repository.Repositories) error {
+
+		if err := repos.User.UpdateEmail(id, input.Email); err != nil {
+			return err
+		}
+
+		return repos.Session.RevokeUserSessions(id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user.Email = input.Email
+	user.Verified = false
+
+	// the new address is the one that needs verifying
+	channel := notification.ChannelEmail
+	if !s.Nc.Supports(channel) {
+		channel = ""
+	}
+	if err := s.GetVerificationCode(user, channel); err != nil {
+		log.Printf("error sending verification code after email change: %v", err)
+	}
+
+	return s.startSession(user, session.TwoFactor)
+}
+
+// SuspendUser blocks or unblocks an account. Suspending ends all of its
+// sessions.
+func (s UserService) SuspendUser(adminId uint, id uint, suspended bool) error {
+
+	if adminId == id {
+		return domain.ErrorModerateSelf
+	}
+
+	if _, err := s.Repo.FindUserById(id); err != nil {
+		return err
+	}
+
+	return s.Uow.Do(func(repos repository.Repositories) error {
+
+		if err := repos.User.UpdateSuspended(id, suspended); err != nil {
+			return err
+		}
+
+		if !suspended {
+			return nil
+		}
+		return repos.Session.RevokeUserSessions(id)
+	})
+}
+
+// ChangeUserRole assigns a role, e.g. to downgrade a seller. Sessions are
+// revoked as issued tokens carry the old role.
+func (s UserService) ChangeUserRole(adminId uint, id uint, role string) error {
+
+	if !domain.IsValidRole(role) {
+		return domain.ErrorInvalidRole
+	}
+
+	if adminId == id {
+		return domain.ErrorModerateSelf
+	}
+
+	if _, err := s.Repo.FindUserById(id); err != nil {
+		return err
+	}
+
+	return s.Uow.Do(func(repos repository.Repositories) error {
+
+		if _, err := repos.User.UpdateUser(id, domain.User{UserType: role}); err != nil {
+			return err
+		}
+
+		return repos.Session.RevokeUserSessions(id)
+	})
+}
+
+// isPlainEmail reports whether email is a bare address. mail.ParseAddress also
+// accepts forms such as "Bob <bob@x.com>", which must not be stored as the
+// login email.
+func isPlainEmail(email string) bool {
+
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Name == "" && addr.Address == email
+}
+
+// CreateAdmin bootstraps an admin account. An existing user with the email
+// is promoted instead and keeps their password.
+func (s UserService) CreateAdmin(email string, password string) error {
+
+	if !isPlainEmail(email) {
+		return domain.ErrorInvalidEmail
+	}
+
+	if user, err := s.findUserByEmail(email); err == nil && user.ID > 0 {
+		if _, err := s.Repo.UpdateUser(user.ID, domain.User{UserType: domain.ADMIN}); err != nil {
+			return err
+		}
+		return s.SRepo.RevokeUserSessions(user.ID)
+	}
+
+	hPassword, err := s.Auth.CreateHashedPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Repo.CreateUser(domain.User{
+		Email:    email,
+		Password: hPassword,
+		UserType: domain.ADMIN,
+		Verified: true,
+	})
+
+	return err
+}
+
+// contactFor resolves the channel and address a user is reached at. An empty
+// channel means SMS when the user has a contact number and SMS is enabled,
+// email otherwise.
+func (s UserService) contactFor(user domain.User, channel notification.Channel) (notification.Channel, string, error) {
+
+	if channel == "" {
+		channel = notification.ChannelEmail
+		if user.Phone != "" && s.Nc.Supports(notification.ChannelSMS) {
+			channel = notification.ChannelSMS
+		}
+	}
+
+	if !s.Nc.Supports(channel) {
+		return "", "", notification.ErrorChannelUnavailable
+	}
+
+	if channel == notification.ChannelSMS {
+		if user.Phone == "" {
+			return "", "", domain.ErrorNoContactNumber
+		}
+		return channel, user.Phone, nil
+	}
+
+	return channel, user.Email, nil
+}
+
+// notify sends a message to the user, see contactFor for how the channel is
+// chosen.
+func (s UserService) notify(user domain.User, channel notification.Channel, subject, message string) error {
 
-	err = s.Auth.VerifyPassword(password, user.Password)
+	channel, to, err := s.contactFor(user, channel)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return s.Auth.GenerateToken(user.ID, user.Email, user.UserType)
-
+	return s.Nc.Send(channel, to, subject, message)
 }
 
 func (s UserService) isVerifiedUser(id uint) bool {
@@ -71,13 +833,30 @@ func (s UserService) isVerifiedUser(id uint) bool {
 	return err == nil && currentUser.Verified
 }
 
-func (s UserService) GetVerificationCode(u domain.User) error {
+// GetVerificationCode sends a new verification code over channel, or the
+// user's default channel when it is empty.
+func (s UserService) GetVerificationCode(u domain.User, channel notification.Channel) error {
+
+	current, err := s.Repo.FindUserById(u.ID)
+	if err != nil {
+		return errors.New("user not found")
+	}
 
-	if s.isVerifiedUser(u.ID) {
+	if current.Verified {
 		fmt.Printf("user already verified \n")
 		return errors.New("user already verified")
 	}
 
+	// reject a channel we can't use before spending an attempt on it
+	if _, _, err := s.contactFor(current, channel); err != nil {
+		return err
+	}
+
+	smsKey := fmt.Sprintf("verify:sms:%d", u.ID)
+	if err := s.checkThrottles(smsKey); err != nil {
+		return err
+	}
+
 	code, err := s.Auth.GenerateVerificationCode()
 	if err != nil {
 		fmt.Printf("error generating verification code %v\n", err)
@@ -97,11 +876,13 @@ func (s UserService) GetVerificationCode(u domain.User) error {
 
 	user, _ = s.Repo.FindUserById(u.ID)
 
-	// Send SMS
+	// a new code gets a fresh set of guesses
+	s.registerAttempt(smsKey, domain.SmsThrottle)
+	s.resetThrottle(fmt.Sprintf("verify:code:%d", u.ID))
+
 	message := fmt.Sprintf("Your code for account verification is %v and is valid for next 30 minutes.", code)
 
-	notificationClient := notification.NewNotificationClient(s.Config)
-	err = notificationClient.SendSMS(user.Phone, message)
+	err = s.notify(user, channel, "Verify your account", message)
 	if err != nil {
 		fmt.Printf("error sending verification code to userId : %d, %v\n", user.ID, err)
 	}
@@ -122,7 +903,13 @@ func (s UserService) VerifyCode(id uint, code int) error {
 		return errors.New("user not found")
 	}
 
+	codeKey := fmt.Sprintf("verify:code:%d", id)
+	if err := s.checkThrottles(codeKey); err != nil {
+		return domain.ErrorVerificationCodeExhausted
+	}
+
 	if user.Code != code {
+		s.registerAttempt(codeKey, domain.VerificationCodeThrottle)
 		return errors.New("verification code doesn't match")
 	}
 
@@ -153,21 +940,13 @@ func (s UserService) CreateProfile(id uint, input dto.ProfileInput) error {
 		return err
 	}
 
-	address := domain.Address{
-		AddressLine1: input.AddressInput.AddressLine1,
-		AddressLine2: input.AddressInput.AddressLine2,
-		City:         input.AddressInput.City,
-		Country:      input.AddressInput.Country,
-		PostCode:     input.AddressInput.PostCode,
-		UserId:       id,
-	}
-
-	err = s.Repo.CreateAddress(address)
-	if err != nil {
-		return err
+	// the profile address goes into the address book
+	if input.AddressInput.IsEmpty() {
+		return nil
 	}
 
-	return nil
+	_, err = s.AddAddress(id, input.AddressInput)
+	return err
 
 }
 
@@ -201,32 +980,203 @@ func (s UserService) UpdateProfile(id uint, input dto.ProfileInput) (*domain.Use
 		return nil, err
 	}
 
+	// the profile address is the default shipping address
+	if !input.AddressInput.IsEmpty() {
+		if address := defaultShippingAddress(user.Addresses); address != nil {
+			_, err = s.UpdateAddress(id, address.ID, input.AddressInput)
+		} else {
+			// later profile updates must find it again
+			input.AddressInput.IsDefaultShipping = true
+			_, err = s.AddAddress(id, input.AddressInput)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	updatedUser.Addresses, err = s.Repo.FindAddresses(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updatedUser, nil
+
+}
+
+func (s UserService) GetAddresses(uId uint) ([]domain.Address, error) {
+
+	return s.Repo.FindAddresses(uId)
+
+}
+
+// AddAddress adds an address to the address book. The first address becomes
+// the default shipping and billing address.
+func (s UserService) AddAddress(uId uint, input dto.AddressInput) (*domain.Address, error) {
+
 	address := domain.Address{
-		AddressLine1: input.AddressInput.AddressLine1,
-		AddressLine2: input.AddressInput.AddressLine2,
-		City:         input.AddressInput.City,
-		Country:      input.AddressInput.Country,
-		PostCode:     input.AddressInput.PostCode,
-		UserId:       id,
+		AddressLine1:      input.AddressLine1,
+		AddressLine2:      input.AddressLine2,
+		City:              input.City,
+		Country:           input.Country,
+		PostCode:          input.PostCode,
+		UserId:            uId,
+		IsDefaultShipping: input.IsDefaultShipping,
+		IsDefaultBilling:  input.IsDefaultBilling,
+	}
+	if !address.IsValid() {
+		return nil, domain.ErrorInvalidAddress
 	}
-	updatedAddress, err := s.Repo.UpdateAddress(address)
+
+	var created *domain.Address
+
+	err := s.Uow.Do(func(repos repository.Repositories) error {
+
+		existing, err := repos.User.FindAddresses(uId)
+		if err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			address.IsDefaultShipping = true
+			address.IsDefaultBilling = true
+		}
+
+		err = repos.User.ClearDefaultAddress(uId, address.IsDefaultShipping, address.IsDefaultBilling)
+		if err != nil {
+			return err
+		}
+
+		created, err = repos.User.CreateAddress(address)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	updatedUser.Address = *updatedAddress
+	return created, nil
+}
 
-	return &updatedUser, nil
+// UpdateAddress changes the fields given in input. The default flags can only
+// be set here, a default is unset by choosing another address.
+func (s UserService) UpdateAddress(uId uint, id uint, input dto.AddressInput) (*domain.Address, error) {
+
+	var updated *domain.Address
+
+	err := s.Uow.Do(func(repos repository.Repositories) error {
 
+		current, err := repos.User.FindAddressById(id, uId)
+		if err != nil {
+			return err
+		}
+
+		if input.AddressLine1 != "" {
+			current.AddressLine1 = input.AddressLine1
+		}
+		if input.AddressLine2 != "" {
+			current.AddressLine2 = input.AddressLine2
+		}
+		if input.City != "" {
+			current.City = input.City
+		}
+		if input.Country != "" {
+			current.Country = input.Country
+		}
+		if input.PostCode != 0 {
+			current.PostCode = input.PostCode
+		}
+		current.IsDefaultShipping = current.IsDefaultShipping || input.IsDefaultShipping
+		current.IsDefaultBilling = current.IsDefaultBilling || input.IsDefaultBilling
+
+		err = repos.User.ClearDefaultAddress(uId, input.IsDefaultShipping, input.IsDefaultBilling)
+		if err != nil {
+			return err
+		}
+
+		updated, err = repos.User.UpdateAddress(current)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteAddress removes an address. A default it held moves to the oldest
+// remaining address, so a user with addresses always has defaults.
+func (s UserService) DeleteAddress(uId uint, id uint) error {
+
+	return s.Uow.Do(func(repos repository.Repositories) error {
+
+		deleted, err := repos.User.FindAddressById(id, uId)
+		if err != nil {
+			return err
+		}
+
+		if err := repos.User.DeleteAddress(id, uId); err != nil {
+			return err
+		}
+
+		if !deleted.IsDefaultShipping && !deleted.IsDefaultBilling {
+			return nil
+		}
+
+		remaining, err := repos.User.FindAddresses(uId)
+		if err != nil || len(remaining) == 0 {
+			return err
+		}
+
+		oldest := remaining[0]
+		for _, a := range remaining[1:] {
+			if a.ID < oldest.ID {
+				oldest = a
+			}
+		}
+
+		oldest.IsDefaultShipping = oldest.IsDefaultShipping || deleted.IsDefaultShipping
+		oldest.IsDefaultBilling = oldest.IsDefaultBilling || deleted.IsDefaultBilling
+
+		_, err = repos.User.UpdateAddress(oldest)
+		return err
+	})
+}
+
+// shippingAddressFor returns the address an order ships to, the given one or
+// the user's default shipping address when addressId is zero.
+func (s UserService) shippingAddressFor(uId uint, addressId uint) (domain.Address, error) {
+
+	if addressId != 0 {
+		return s.Repo.FindAddressById(addressId, uId)
+	}
+
+	addresses, err := s.Repo.FindAddresses(uId)
+	if err != nil {
+		return domain.Address{}, err
+	}
+
+	address := defaultShippingAddress(addresses)
+	if address == nil {
+		return domain.Address{}, domain.ErrorShippingAddressRequired
+	}
+
+	return *address, nil
+}
+
+func defaultShippingAddress(addresses []domain.Address) *domain.Address {
+	for i := range addresses {
+		if addresses[i].IsDefaultShipping {
+			return &addresses[i]
+		}
+	}
+	return nil
 }
 
-func (s UserService) BecomeSeller(id uint, input dto.SellerInput) (string, error) {
+func (s UserService) BecomeSeller(id uint, input dto.SellerInput) (*dto.AuthTokens, error) {
 
 	user, _ := s.Repo.FindUserById(id)
 
 	if user.UserType == domain.SELLER {
 		fmt.Println("The user is already a seller")
-		return "", errors.New("you are already a seller")
+		return nil, errors.New("you are already a seller")
 	}
 
 	updatedUser := domain.User{
@@ -238,12 +1188,7 @@ func (s UserService) BecomeSeller(id uint, input dto.SellerInput) (string, error
 
 	seller, err := s.Repo.UpdateUser(id, updatedUser)
 	if err != nil {
-		return "", err
-	}
-
-	token, err := s.Auth.GenerateToken(id, user.Email, seller.UserType)
-	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	account := domain.BankAccount{
@@ -256,35 +1201,177 @@ func (s UserService) BecomeSeller(id uint, input dto.SellerInput) (string, error
 	err = s.Repo.CreateBankAccount(account)
 	if err != nil {
 		fmt.Printf("Error occurred while creating bank record for user %v", err)
-		return "", errors.New("error creating bank info")
+		return nil, errors.New("error creating bank info")
 	}
 
-	return token, nil
+	// tokens issued before carry the old role
+	err = s.SRepo.RevokeUserSessions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user.UserType = seller.UserType
+	return s.startSession(user, false)
 
 }
 
-func (s UserService) FindCart(id uint) ([]domain.Cart, float64, error) {
+// FindCart returns the cart reconciled against the current products, see
+// reconcileCart. The total uses the prices the buyer has accepted.
+func (s UserService) FindCart(id uint) ([]domain.Cart, money.Money, error) {
 
 	cartItems, err := s.Repo.FindCartItems(id)
 	if err != nil {
-		return nil, 0, err
+		return nil, money.Money{}, err
 	}
 
-	var totalAmount float64
+	if err := s.reconcileCart(id, cartItems); err != nil {
+		return nil, money.Money{}, err
+	}
+
+	var totalAmount money.Money
 	for _, item := range cartItems {
-		totalAmount += item.Price * float64(item.Qty)
+		totalAmount, err = totalAmount.Add(item.Price.Mul(item.Qty))
+		if err != nil {
+			return nil, money.Money{}, err
+		}
 	}
 
 	return cartItems, totalAmount, nil
 
 }
 
+// CheckoutCart returns the cart for a new payment session. It fails with
+// ErrorCartNeedsReview while any line has unacknowledged changes or not
+// enough stock.
+func (s UserService) CheckoutCart(uId uint) ([]domain.Cart, money.Money, error) {
+
+	cartItems, amount, err := s.FindCart(uId)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	for _, item := range cartItems {
+		if len(item.Issues) > 0 {
+			return nil, money.Money{}, domain.ErrorCartNeedsReview
+		}
+	}
+
+	return cartItems, amount, nil
+}
+
+// AcknowledgeCart accepts the changes found by reconcileCart: lines take the
+// current product price and removed products leave the cart. Out of stock
+// lines stay flagged until the buyer lowers the quantity.
+func (s UserService) AcknowledgeCart(uId uint) ([]domain.Cart, error) {
+
+	cartItems, err := s.Repo.FindCartItems(uId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.reconcileCart(uId, cartItems); err != nil {
+		return nil, err
+	}
+
+	var changed []domain.Cart
+	for _, item := range cartItems {
+		if slices.Contains(item.Issues, domain.CartIssueUnavailable) || item.CurrentPrice != nil {
+			changed = append(changed, item)
+		}
+	}
+	if len(changed) == 0 {
+		cartItems, _, err = s.FindCart(uId)
+		return cartItems, err
+	}
+
+	// the open payment session no longer matches the cart
+	if err := s.invalidateCheckout(uId); err != nil {
+		return nil, err
+	}
+
+	for _, item := range changed {
+		if item.CurrentPrice == nil {
+			err = s.Repo.DeleteCartById(item.ID)
+		} else {
+			item.Price = *item.CurrentPrice
+			err = s.Repo.UpdateCart(item)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cartItems, _, err = s.FindCart(uId)
+	return cartItems, err
+}
+
+// reconcileCart compares each line with its product. Name and image are
+// refreshed in place, price changes, missing stock and removed products are
+// reported as issues on the line.
+func (s UserService) reconcileCart(uId uint, cartItems []domain.Cart) error {
+
+	if len(cartItems) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(cartItems))
+	for _, item := range cartItems {
+		ids = append(ids, item.ProductId)
+	}
+
+	products, err := s.PRepo.FindProductsByIds(ids)
+	if err != nil {
+		return err
+	}
+
+	// units the buyer holds for an open payment session are theirs to buy
+	reserved, err := s.PRepo.ReservedStock(ids, uId)
+	if err != nil {
+		return err
+	}
+
+	productById := make(map[uint]*domain.Product, len(products))
+	for _, product := range products {
+		productById[product.ID] = product
+	}
+
+	for i := range cartItems {
+		item := &cartItems[i]
+
+		product, ok := productById[item.ProductId]
+		if !ok {
+			item.Issues = append(item.Issues, domain.CartIssueUnavailable)
+			continue
+		}
+
+		item.Name = product.Name
+		item.ImageUrl = product.ImageUrl
+
+		if product.Price != item.Price {
+			price := product.Price
+			item.CurrentPrice = &price
+			item.Issues = append(item.Issues, domain.CartIssuePriceChanged)
+		}
+
+		if product.Stock < reserved[product.ID]+item.Qty {
+			item.Issues = append(item.Issues, domain.CartIssueOutOfStock)
+		}
+	}
+
+	return nil
+}
+
 func (s UserService) CreateCart(input dto.CreateCartRequest, u domain.User) ([]domain.Cart, error) {
 
 	if input.ProductId == 0 {
 		return nil, errors.New("please provide a valid product id")
 	}
 
+	// the open payment session no longer matches the cart
+	if err := s.invalidateCheckout(u.ID); err != nil {
+		return nil, err
+	}
+
 	cart, _ := s.Repo.FindCartItem(u.ID, input.ProductId)
 	if cart.ID > 0 {
 
@@ -331,56 +1418,191 @@ func (s UserService) CreateCart(input dto.CreateCartRequest, u domain.User) ([]d
 
 }
 
-func (s UserService) CreateOrder(uId uint, orderRef string, pId string, amount float64) error {
+// CreateOrder creates the order for a confirmed payment from the checkout
+// snapshot taken when the payment session started, so cart changes made
+// after paying are never part of the order. It is idempotent on orderRef so
+// payment verification and the payment webhook can both call it.
+func (s UserService) CreateOrder(uId uint, orderRef string, pId string) error {
+
+	if s.orderExists(orderRef, uId) {
+		return nil
+	}
 
-	// get cart items
-	cartItems, _, err := s.FindCart(uId)
+	snapshot, err := s.TRepo.FindCheckoutSnapshot(orderRef)
 	if err != nil {
 		return err
 	}
-	if len(cartItems) == 0 {
-		return domain.ErrorCartItemNotFound
+	if snapshot.UserId != uId {
+		return domain.ErrorCheckoutSnapshotNotFound
 	}
 
 	// create order with generated order ref
 	var orderItems []domain.OrderItem
+	var productIds []uint
 
-	for _, item := range cartItems {
+	for _, item := range snapshot.Items {
 		orderItems = append(orderItems, domain.OrderItem{
 			ProductId: item.ProductId,
-			UserId:    item.UserId,
+			UserId:    uId,
 			Name:      item.Name,
 			ImageUrl:  item.ImageUrl,
 			SellerId:  item.SellerId,
 			Price:     item.Price,
 			Qty:       item.Qty,
 		})
+		productIds = append(productIds, item.ProductId)
 	}
 
+	// orders are only created once the payment has been confirmed
 	order := domain.Order{
 		UserId:    uId,
-		Amount:    amount,
+		Status:    domain.OrderStatusPaid,
+		Amount:    snapshot.Amount,
 		OrderRef:  orderRef,
 		PaymentId: pId,
 		Items:     orderItems,
+		// where to ship was decided when the checkout started
+		ShippingAddress: snapshot.ShippingAddress,
+		History: []domain.OrderStatusHistory{{
+			FromStatus: domain.OrderStatusPending,
+			ToStatus:   domain.OrderStatusPaid,
+			ActorRole:  domain.SYSTEM,
+		}},
 	}
 
-	err = s.Repo.CreateOrder(order)
+	// lock products in a stable order so concurrent checkouts can't deadlock
+	sort.Slice(orderItems, func(i, j int) bool {
+		return orderItems[i].ProductId < orderItems[j].ProductId
+	})
+
+	// stock, order and cart change together or not at all
+	err = s.Uow.Do(func(repos repository.Repositories) error {
+
+		// the buyer's own reservations must not count against the purchase
+		err := repos.Product.UpdateReservations(orderRef, domain.ReservationStatusActive, domain.ReservationStatusConverted)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range orderItems {
+			if err := repos.Product.DecrementStock(item.ProductId, item.Qty); err != nil {
+				return err
+			}
+		}
+
+		if err := repos.User.CreateOrder(order); err != nil {
+			return err
+		}
+
+		// leave anything added to the cart after paying for the next checkout
+		return repos.User.DeleteCartProducts(uId, productIds)
+	})
 	if err != nil {
+		// order_ref is unique, so losing a race surfaces as a create error
+		if s.orderExists(orderRef, uId) {
+			return nil
+		}
 		return err
 	}
 
 	// send order confirmation email to user
 
-	// remove cart items
-	s.Repo.DeleteCartItems(uId)
-
 	// return order number
 
 	return nil
 
 }
 
+// invalidateCheckout cancels the buyer's open payment session and releases
+// its stock. If the gateway refuses to cancel, the buyer has most likely
+// already paid and the order will be created from the snapshot.
+func (s UserService) invalidateCheckout(uId uint) error {
+
+	p, err := s.TRepo.FindInitialPayment(uId)
+	if err != nil {
+		if errors.Is(err, domain.ErrorUserInitialPaymentNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if _, err := s.Pc.CancelPayment(p.PaymentId); err != nil {
+		log.Printf("payment %s not cancelled on cart change: %v", p.PaymentId, err)
+		return nil
+	}
+
+	err = s.TRepo.UpdatePaymentStatus(p, domain.PaymentStatusFailed, "cart changed")
+	if err != nil {
+		return err
+	}
+
+	return s.PRepo.UpdateReservations(p.OrderId, domain.ReservationStatusActive, domain.ReservationStatusReleased)
+}
+
+// StartCheckout snapshots the cart and holds stock for every cart item while
+// the payment session identified by orderRef is open. The order ships to
+// addressId, or the default shipping address when it is zero. Either all of
+// it is stored or none.
+func (s UserService) StartCheckout(uId uint, orderRef string, cartItems []domain.Cart, amount money.Money, addressId uint) error {
+
+	shipping, err := s.shippingAddressFor(uId, addressId)
+	if err != nil {
+		return err
+	}
+
+	items := append([]domain.Cart{}, cartItems...)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ProductId < items[j].ProductId
+	})
+
+	snapshot := domain.CheckoutSnapshot{
+		OrderRef:        orderRef,
+		UserId:          uId,
+		Amount:          amount,
+		ShippingAddress: shipping.Snapshot(),
+	}
+	for _, item := range items {
+		snapshot.Items = append(snapshot.Items, domain.CheckoutSnapshotItem{
+			ProductId: item.ProductId,
+			Name:      item.Name,
+			ImageUrl:  item.ImageUrl,
+			SellerId:  item.SellerId,
+			Price:     item.Price,
+			Qty:       item.Qty,
+		})
+	}
+
+	expiresAt := time.Now().Add(domain.StockReservationTTL)
+
+	return s.Uow.Do(func(repos repository.Repositories) error {
+
+		if err := repos.Transaction.CreateCheckoutSnapshot(&snapshot); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			err := repos.Product.ReserveStock(domain.StockReservation{
+				ProductId: item.ProductId,
+				UserId:    uId,
+				OrderRef:  orderRef,
+				Qty:       item.Qty,
+				Status:    domain.ReservationStatusActive,
+				ExpiresAt: expiresAt,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s UserService) orderExists(orderRef string, uId uint) bool {
+
+	_, err := s.Repo.FindUserOrderById(orderRef, uId)
+	return err == nil
+}
+
 func (s UserService) GetOrders(uId uint) ([]domain.Order, error) {
 
 	return s.Repo.FindOrders(uId)
@@ -3,7 +3,9 @@ package main
 import (
 	"ecommerce/config"
 	"ecommerce/internal/api"
+	"flag"
 	"log"
+	"os"
 )
 
 func main() {
@@ -13,6 +15,36 @@ func main() {
 		log.Fatalf("Config file is not loaded properly %v\n", err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		createAdmin(cfg, os.Args[2:])
+		return
+	}
+
 	api.StartServer(cfg)
 
 }
+
+// createAdmin bootstraps the first admin:
+//
+//	ADMIN_PASSWORD=... go run main.go create-admin -email admin@example.com
+func createAdmin(cfg config.AppConfig, args []string) {
+
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "email of the admin account")
+	flags.Parse(args)
+
+	// read from the environment so the password doesn't end up in the shell history
+	password := os.Getenv("ADMIN_PASSWORD")
+
+	if *email == "" {
+		log.Fatalf("create-admin: -email is required")
+	}
+
+	err := api.CreateAdmin(cfg, *email, password)
+	if err != nil {
+		log.Fatalf("create-admin: %v\n", err)
+	}
+
+	log.Printf("admin %s is ready", *email)
+
+}
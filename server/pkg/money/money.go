@@ -0,0 +1,208 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used for amounts received without a currency code.
+const DefaultCurrency = "USD"
+
+var (
+	ErrorInvalidAmount    = errors.New("invalid money amount")
+	ErrorCurrencyMismatch = errors.New("money amounts have different currencies")
+)
+
+// exponents holds the number of minor units of currencies that don't use cents.
+var exponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+}
+
+// Money is an exact amount in the minor units (e.g. cents) of an ISO 4217
+// currency. It is stored as two columns, <prefix>minor and <prefix>currency,
+// and encoded in JSON as a plain decimal number so API clients keep reading
+// prices as numbers.
+type Money struct {
+	Amount   int64  `gorm:"column:minor;not null;default:0"`
+	Currency string `gorm:"column:currency;size:3;not null;default:'USD'"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: normalize(currency)}
+}
+
+// Parse reads a decimal string such as "19.99" without going through float64.
+// Digits beyond the currency's minor units are rounded half away from zero.
+func Parse(s string, currency string) (Money, error) {
+
+	currency = normalize(currency)
+	exp := Exponent(currency)
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return Money{}, ErrorInvalidAmount
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	// check every digit before rounding drops the ones past the minor units
+	if !isDigits(whole) || !isDigits(frac) {
+		return Money{}, ErrorInvalidAmount
+	}
+
+	roundUp := false
+	if len(frac) > exp {
+		roundUp = frac[exp] >= '5'
+		frac = frac[:exp]
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, ErrorInvalidAmount
+	}
+	if roundUp {
+		minor++
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromFloat converts a legacy float amount, rounding to the nearest minor unit.
+func FromFloat(f float64, currency string) Money {
+	m, _ := Parse(strconv.FormatFloat(f, 'f', -1, 64), currency)
+	return m
+}
+
+// Exponent returns the number of minor unit digits of the currency.
+func Exponent(currency string) int {
+	if exp, ok := exponents[normalize(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+func normalize(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// Mul returns the amount multiplied by qty, e.g. a line total.
+func (m Money) Mul(qty uint) Money {
+	return Money{Amount: m.Amount * int64(qty), Currency: m.Currency}
+}
+
+// Add sums two amounts of the same currency. The zero Money value takes the
+// currency of the other operand so totals can start from Money{}.
+func (m Money) Add(o Money) (Money, error) {
+
+	if m.Currency == "" {
+		return o, nil
+	}
+	if o.Currency == "" {
+		return m, nil
+	}
+	if normalize(m.Currency) != normalize(o.Currency) {
+		return Money{}, ErrorCurrencyMismatch
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: normalize(m.Currency)}, nil
+}
+
+// String formats the amount as a decimal, e.g. "19.99".
+func (m Money) String() string {
+
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return fmt.Sprintf("%s%s.%s", sign, digits[:len(digits)-exp], digits[len(digits)-exp:])
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number (19.99), a numeric string ("19.99") or an
+// object ({"amount": 1999, "currency": "USD"}) with the amount in minor units.
+func (m *Money) UnmarshalJSON(data []byte) error {
+
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Amount   int64  `json:"amount"`
+			Currency string `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return ErrorInvalidAmount
+		}
+		*m = New(v.Amount, v.Currency)
+		return nil
+	}
+
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return ErrorInvalidAmount
+		}
+	}
+
+	parsed, err := Parse(s, m.Currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,52 @@
+package notification
+
+import (
+	"ecommerce/config"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type emailSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewEmailSender sends plain text emails through an SMTP server. Servers
+// without credentials are used unauthenticated.
+func NewEmailSender(cfg config.SmtpConfig) Sender {
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return &emailSender{
+		addr: net.JoinHostPort(cfg.Host, cfg.Port),
+		auth: auth,
+		from: cfg.From,
+	}
+}
+
+// Send implements Sender.
+func (s emailSender) Send(to, subject, message string) error {
+
+	// header values must not be able to inject more headers
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.from, to, subject, message)
+
+	err := smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(body))
+	if err != nil {
+		log.Printf("error sending email: %v", err)
+		return err
+	}
+
+	return nil
+}
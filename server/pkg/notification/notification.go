@@ -0,0 +1,95 @@
+package notification
+
+import (
+	"ecommerce/config"
+	"errors"
+)
+
+var (
+	ErrorInvalidChannel     = errors.New("notification channel must be sms or email")
+	ErrorChannelUnavailable = errors.New("notification channel is not available")
+)
+
+// Channel is the way a message reaches a user.
+type Channel string
+
+const (
+	ChannelSMS   Channel = "sms"
+	ChannelEmail Channel = "email"
+)
+
+// ParseChannel validates a channel received from a client. An empty value
+// is returned as is so callers can pick a default.
+func ParseChannel(s string) (Channel, error) {
+	switch c := Channel(s); c {
+	case "", ChannelSMS, ChannelEmail:
+		return c, nil
+	}
+	return "", ErrorInvalidChannel
+}
+
+// Sender delivers messages over a single channel. to is a phone number or an
+// email address depending on the channel.
+type Sender interface {
+	Send(to, subject, message string) error
+}
+
+// NotificationClient sends messages over the channels enabled in config.
+type NotificationClient interface {
+	Send(channel Channel, to, subject, message string) error
+	Supports(channel Channel) bool
+}
+
+type notificationClient struct {
+	senders map[Channel]Sender
+}
+
+// NewNotificationClient returns a client for the given senders, channels
+// without a sender are unavailable.
+func NewNotificationClient(senders map[Channel]Sender) NotificationClient {
+	return &notificationClient{
+		senders: senders,
+	}
+}
+
+// NewNotifier returns the notification client for the channels configured.
+// With the stub enabled every channel is written to the log (or a file)
+// instead of being delivered.
+func NewNotifier(cfg config.AppConfig) NotificationClient {
+
+	senders := map[Channel]Sender{}
+
+	if cfg.NotificationStub {
+		stub := NewStubSender(cfg.NotificationStubFile)
+		senders[ChannelSMS] = stub.For(ChannelSMS)
+		senders[ChannelEmail] = stub.For(ChannelEmail)
+		return NewNotificationClient(senders)
+	}
+
+	if cfg.TwilioConfig.AccountSID != "" {
+		senders[ChannelSMS] = NewSMSSender(cfg.TwilioConfig)
+	}
+
+	if cfg.SmtpConfig.Host != "" {
+		senders[ChannelEmail] = NewEmailSender(cfg.SmtpConfig)
+	}
+
+	return NewNotificationClient(senders)
+}
+
+// Send implements NotificationClient.
+func (c notificationClient) Send(channel Channel, to, subject, message string) error {
+
+	sender, ok := c.senders[channel]
+	if !ok {
+		return ErrorChannelUnavailable
+	}
+
+	return sender.Send(to, subject, message)
+}
+
+// Supports implements NotificationClient.
+func (c notificationClient) Supports(channel Channel) bool {
+	_, ok := c.senders[channel]
+	return ok
+}
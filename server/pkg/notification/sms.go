@@ -0,0 +1,46 @@
+package notification
+
+import (
+	"ecommerce/config"
+	"log"
+
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+type smsSender struct {
+	client *twilio.RestClient
+	from   string
+}
+
+// NewSMSSender sends text messages through Twilio. SMS have no subject.
+func NewSMSSender(cfg config.TwilioConfig) Sender {
+	return &smsSender{
+		client: twilio.NewRestClientWithParams(twilio.ClientParams{
+			Username: cfg.AccountSID,
+			Password: cfg.AuthToken,
+		}),
+		from: cfg.FromContactNumber,
+	}
+}
+
+// Send implements Sender.
+func (s smsSender) Send(to, subject, message string) error {
+
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(s.from)
+	params.SetBody(message)
+
+	resp, err := s.client.Api.CreateMessage(params)
+	if err != nil {
+		log.Printf("error sending SMS message: %v", err)
+		return err
+	}
+
+	if resp.Sid != nil {
+		log.Printf("SMS message sent: %s", *resp.Sid)
+	}
+
+	return nil
+}
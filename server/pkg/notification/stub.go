@@ -0,0 +1,59 @@
+package notification
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// StubSender records messages instead of delivering them, for local
+// development. Messages are appended to a file when one is set, otherwise
+// written to the log.
+type StubSender struct {
+	mu   sync.Mutex
+	file string
+}
+
+func NewStubSender(file string) *StubSender {
+	return &StubSender{file: file}
+}
+
+// For returns a Sender that records messages as sent over channel.
+func (s *StubSender) For(channel Channel) Sender {
+	return stubChannel{stub: s, channel: channel}
+}
+
+func (s *StubSender) write(channel Channel, to, subject, message string) error {
+
+	line := fmt.Sprintf("[%s] to=%s subject=%q message=%q", channel, to, subject, message)
+
+	if s.file == "" {
+		log.Printf("notification stub %s", line)
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("error opening notification stub file: %v", err)
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s %s\n", time.Now().Format(time.RFC3339), line)
+	return err
+}
+
+type stubChannel struct {
+	stub    *StubSender
+	channel Channel
+}
+
+// Send implements Sender.
+func (c stubChannel) Send(to, subject, message string) error {
+	return c.stub.write(c.channel, to, subject, message)
+}
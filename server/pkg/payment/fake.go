@@ -0,0 +1,211 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"ecommerce/pkg/money"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// FakeSignatureHeader carries the hex encoded HMAC-SHA256 of a fake webhook
+// payload, keyed with the webhook secret.
+const FakeSignatureHeader = "Fake-Signature"
+
+// fakePayment is a deterministic in-memory provider for local development and
+// CI. Intents get sequential ids and are confirmed the first time their status
+// is read, as if the buyer completed the card form straight away.
+type fakePayment struct {
+	mu       sync.Mutex
+	intents  map[string]*PaymentIntent
+	refunded map[string]int64
+	seq      int
+
+	// refunds remembers refunds by idempotency key
+	refunds map[string]*Refund
+
+	webhookSecret string
+}
+
+// CreatePayment implements PaymentClient.
+func (p *fakePayment) CreatePayment(amount money.Money, userId uint, orderId string) (*PaymentIntent, error) {
+
+	if !amount.IsPositive() {
+		return nil, errors.New("payment creation failed")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	id := fmt.Sprintf("fake_pi_%d", p.seq)
+
+	intent := &PaymentIntent{
+		ID:           id,
+		ClientSecret: id + "_secret",
+		Amount:       amount,
+		Status:       IntentStatusRequiresPayment,
+		Metadata: map[string]string{
+			"user_id":  fmt.Sprintf("%d", userId),
+			"order_id": orderId,
+		},
+	}
+	p.intents[id] = intent
+
+	return copyIntent(intent), nil
+}
+
+// GetPaymentStatus implements PaymentClient.
+func (p *fakePayment) GetPaymentStatus(pId string) (*PaymentIntent, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[pId]
+	if !ok {
+		return nil, ErrorPaymentIntentNotFound
+	}
+
+	if intent.Status == IntentStatusRequiresPayment {
+		intent.Status = IntentStatusSucceeded
+	}
+
+	return copyIntent(intent), nil
+}
+
+// CapturePayment implements PaymentClient.
+func (p *fakePayment) CapturePayment(pId string) (*PaymentIntent, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[pId]
+	if !ok {
+		return nil, ErrorPaymentIntentNotFound
+	}
+	if intent.Status != IntentStatusRequiresCapture {
+		return nil, errors.New("payment capture failed")
+	}
+
+	intent.Status = IntentStatusSucceeded
+	return copyIntent(intent), nil
+}
+
+// CancelPayment implements PaymentClient.
+func (p *fakePayment) CancelPayment(pId string) (*PaymentIntent, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[pId]
+	if !ok {
+		return nil, ErrorPaymentIntentNotFound
+	}
+	if intent.Status == IntentStatusSucceeded {
+		return nil, errors.New("payment cancellation failed")
+	}
+
+	intent.Status = IntentStatusCanceled
+	return copyIntent(intent), nil
+}
+
+// RefundPayment implements PaymentClient.
+func (p *fakePayment) RefundPayment(pId string, amount money.Money, idempotencyKey string) (*Refund, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if refund, ok := p.refunds[idempotencyKey]; ok {
+		c := *refund
+		return &c, nil
+	}
+
+	intent, ok := p.intents[pId]
+	if !ok {
+		return nil, ErrorPaymentIntentNotFound
+	}
+	if intent.Status != IntentStatusSucceeded {
+		return nil, errors.New("payment refund failed")
+	}
+
+	remaining := intent.Amount.Amount - p.refunded[pId]
+	if amount.IsZero() {
+		amount = money.New(remaining, intent.Amount.Currency)
+	}
+	if !amount.IsPositive() || amount.Amount > remaining || amount.Currency != intent.Amount.Currency {
+		return nil, errors.New("payment refund failed")
+	}
+
+	p.seq++
+	p.refunded[pId] += amount.Amount
+
+	refund := &Refund{
+		ID:        fmt.Sprintf("fake_re_%d", p.seq),
+		PaymentId: pId,
+		Amount:    amount,
+		Status:    RefundStatusSucceeded,
+	}
+	if idempotencyKey != "" {
+		p.refunds[idempotencyKey] = refund
+	}
+
+	c := *refund
+	return &c, nil
+}
+
+// ParseWebhookEvent implements PaymentClient.
+// The payload is the JSON encoding of an Event, signed as in SignFakeWebhook.
+func (p *fakePayment) ParseWebhookEvent(payload []byte, header http.Header) (*Event, error) {
+
+	if p.webhookSecret == "" {
+		return nil, errors.New("payment webhook is not configured")
+	}
+
+	signature, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(signature, fakeSignature(payload, p.webhookSecret)) {
+		return nil, errors.New("invalid webhook signature")
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, errors.New("invalid webhook payload")
+	}
+
+	event.Raw = string(payload)
+	return &event, nil
+}
+
+// SignFakeWebhook returns the headers the fake provider expects with payload
+// when its webhook secret is secret. Local fixtures and tests use it to sign
+// events.
+func SignFakeWebhook(payload []byte, secret string) http.Header {
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, hex.EncodeToString(fakeSignature(payload, secret)))
+	return header
+}
+
+func fakeSignature(payload []byte, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func copyIntent(intent *PaymentIntent) *PaymentIntent {
+	c := *intent
+	return &c
+}
+
+// NewFakePaymentClient returns the in-memory provider. Webhooks are rejected
+// when webhookSecret is empty.
+func NewFakePaymentClient(webhookSecret string) PaymentClient {
+	return &fakePayment{
+		intents:       map[string]*PaymentIntent{},
+		refunded:      map[string]int64{},
+		refunds:       map[string]*Refund{},
+		webhookSecret: webhookSecret,
+	}
+}
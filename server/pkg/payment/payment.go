@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"ecommerce/config"
+	"ecommerce/pkg/money"
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrorPaymentIntentNotFound = errors.New("payment intent not found")
+)
+
+// PaymentClient is implemented by every payment provider. Handlers and
+// services only ever see the provider neutral types below.
+type PaymentClient interface {
+	CreatePayment(amount money.Money, userId uint, orderId string) (*PaymentIntent, error)
+	GetPaymentStatus(pId string) (*PaymentIntent, error)
+	CapturePayment(pId string) (*PaymentIntent, error)
+	CancelPayment(pId string) (*PaymentIntent, error)
+	// RefundPayment refunds amount of the payment, or all of it when amount is
+	// zero. Calls repeated with the same idempotencyKey refund only once.
+	RefundPayment(pId string, amount money.Money, idempotencyKey string) (*Refund, error)
+	// ParseWebhookEvent verifies the signature the provider put in the
+	// request header and decodes the event in payload.
+	ParseWebhookEvent(payload []byte, header http.Header) (*Event, error)
+}
+
+type IntentStatus string
+
+const (
+	IntentStatusRequiresPayment IntentStatus = "requires_payment"
+	IntentStatusRequiresAction  IntentStatus = "requires_action"
+	IntentStatusRequiresCapture IntentStatus = "requires_capture"
+	IntentStatusProcessing      IntentStatus = "processing"
+	IntentStatusSucceeded       IntentStatus = "succeeded"
+	IntentStatusCanceled        IntentStatus = "canceled"
+	IntentStatusFailed          IntentStatus = "failed"
+)
+
+type PaymentIntent struct {
+	ID           string            `json:"id"`
+	ClientSecret string            `json:"-"`
+	Amount       money.Money       `json:"amount"`
+	Status       IntentStatus      `json:"status"`
+	Metadata     map[string]string `json:"metadata"`
+}
+
+type RefundStatus string
+
+const (
+	RefundStatusPending   RefundStatus = "pending"
+	RefundStatusSucceeded RefundStatus = "succeeded"
+	RefundStatusFailed    RefundStatus = "failed"
+	RefundStatusCanceled  RefundStatus = "canceled"
+)
+
+type Refund struct {
+	ID        string       `json:"id"`
+	PaymentId string       `json:"payment_id"`
+	Amount    money.Money  `json:"amount"`
+	Status    RefundStatus `json:"status"`
+}
+
+type EventType string
+
+const (
+	EventPaymentSucceeded EventType = "payment.succeeded"
+	EventPaymentFailed    EventType = "payment.failed"
+	EventPaymentRefunded  EventType = "payment.refunded"
+)
+
+// Event is a verified webhook notification. Events a provider sends that we
+// do not handle are returned with an empty Type.
+type Event struct {
+	ID            string    `json:"id"`
+	Type          EventType `json:"type"`
+	PaymentId     string    `json:"payment_id"`
+	FullyRefunded bool      `json:"fully_refunded"`
+	Raw           string    `json:"-"`
+}
+
+// NewGateway returns the payment client for the provider selected in config.
+func NewGateway(cfg config.AppConfig) PaymentClient {
+
+	if cfg.PaymentProvider == config.PaymentProviderFake {
+		return NewFakePaymentClient(cfg.FakeWebhookSecret)
+	}
+
+	return NewPaymentClient(cfg.StripeConfig.StripeSecretKey, cfg.StripeConfig.SuccessUrl, cfg.StripeConfig.CancelUrl, cfg.StripeConfig.WebhookSecret)
+}
@@ -1,33 +1,34 @@
 package payment
 
 import (
+	"ecommerce/pkg/money"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 
 	"github.com/stripe/stripe-go/v78"
 	"github.com/stripe/stripe-go/v78/paymentintent"
+	"github.com/stripe/stripe-go/v78/refund"
+	"github.com/stripe/stripe-go/v78/webhook"
 )
 
-type PaymentClient interface {
-	CreatePayment(amount float64, userId uint, orderId string) (*stripe.PaymentIntent, error)
-	GetPaymentStatus(pId string) (*stripe.PaymentIntent, error)
-}
-
 type payment struct {
 	stripeSecretKey string
 	successUrl      string
 	faliureUrl      string
+	webhookSecret   string
 }
 
 // CreatePayment implements PaymentClient.
-func (p *payment) CreatePayment(amount float64, userId uint, orderId string) (*stripe.PaymentIntent, error) {
+func (p *payment) CreatePayment(amount money.Money, userId uint, orderId string) (*PaymentIntent, error) {
 	stripe.Key = p.stripeSecretKey
-	amountInCents := int64(amount * 100)
 
 	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(amountInCents),
-		Currency:           stripe.String(string(stripe.CurrencyUSD)),
+		Amount:             stripe.Int64(amount.Amount),
+		Currency:           stripe.String(strings.ToLower(amount.Currency)),
 		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
 	}
 
@@ -41,11 +42,11 @@ func (p *payment) CreatePayment(amount float64, userId uint, orderId string) (*s
 	}
 
 	// Log or return session.URL if you want to redirect user
-	return pi, nil
+	return toPaymentIntent(pi), nil
 }
 
 // GetPaymentStatus implements PaymentClient.
-func (p *payment) GetPaymentStatus(pId string) (*stripe.PaymentIntent, error) {
+func (p *payment) GetPaymentStatus(pId string) (*PaymentIntent, error) {
 
 	stripe.Key = p.stripeSecretKey
 	params := &stripe.PaymentIntentParams{}
@@ -56,14 +57,148 @@ func (p *payment) GetPaymentStatus(pId string) (*stripe.PaymentIntent, error) {
 		return nil, errors.New("error fetching payment status")
 	}
 
+	return toPaymentIntent(result), nil
+
+}
+
+// CapturePayment implements PaymentClient.
+func (p *payment) CapturePayment(pId string) (*PaymentIntent, error) {
+
+	stripe.Key = p.stripeSecretKey
+
+	result, err := paymentintent.Capture(pId, &stripe.PaymentIntentCaptureParams{})
+	if err != nil {
+		log.Printf("Error capturing payment intent: %v", err)
+		return nil, errors.New("payment capture failed")
+	}
+
+	return toPaymentIntent(result), nil
+}
+
+// CancelPayment implements PaymentClient.
+func (p *payment) CancelPayment(pId string) (*PaymentIntent, error) {
+
+	stripe.Key = p.stripeSecretKey
+
+	result, err := paymentintent.Cancel(pId, &stripe.PaymentIntentCancelParams{})
+	if err != nil {
+		log.Printf("Error cancelling payment intent: %v", err)
+		return nil, errors.New("payment cancellation failed")
+	}
+
+	return toPaymentIntent(result), nil
+}
+
+// RefundPayment implements PaymentClient.
+func (p *payment) RefundPayment(pId string, amount money.Money, idempotencyKey string) (*Refund, error) {
+
+	stripe.Key = p.stripeSecretKey
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(pId),
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+	if amount.IsPositive() {
+		params.Amount = stripe.Int64(amount.Amount)
+	}
+
+	result, err := refund.New(params)
+	if err != nil {
+		log.Printf("Error creating refund: %v", err)
+		return nil, errors.New("payment refund failed")
+	}
+
+	return &Refund{
+		ID:        result.ID,
+		PaymentId: pId,
+		Amount:    money.New(result.Amount, string(result.Currency)),
+		Status:    RefundStatus(result.Status),
+	}, nil
+}
+
+// ParseWebhookEvent implements PaymentClient.
+// Payloads signed with webhook.GenerateTestSignedPayload and the configured
+// secret are accepted, which allows local fixtures to exercise the webhook.
+func (p *payment) ParseWebhookEvent(payload []byte, header http.Header) (*Event, error) {
+
+	if p.webhookSecret == "" {
+		return nil, errors.New("payment webhook is not configured")
+	}
+
+	event, err := webhook.ConstructEventWithOptions(payload, header.Get("Stripe-Signature"), p.webhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		log.Printf("Error verifying webhook signature: %v", err)
+		return nil, errors.New("invalid webhook signature")
+	}
+
+	result := &Event{ID: event.ID, Raw: string(event.Data.Raw)}
+
+	switch event.Type {
+	case stripe.EventTypePaymentIntentSucceeded, stripe.EventTypePaymentIntentPaymentFailed:
+
+		var intent stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &intent); err != nil {
+			return nil, errors.New("invalid payment intent payload")
+		}
+
+		result.PaymentId = intent.ID
+		result.Type = EventPaymentFailed
+		if event.Type == stripe.EventTypePaymentIntentSucceeded {
+			result.Type = EventPaymentSucceeded
+		}
+
+	case stripe.EventTypeChargeRefunded:
+
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return nil, errors.New("invalid charge payload")
+		}
+		if charge.PaymentIntent == nil {
+			return result, nil
+		}
+
+		result.Type = EventPaymentRefunded
+		result.PaymentId = charge.PaymentIntent.ID
+		result.FullyRefunded = charge.Refunded
+	}
+
 	return result, nil
+}
+
+func toPaymentIntent(pi *stripe.PaymentIntent) *PaymentIntent {
+	return &PaymentIntent{
+		ID:           pi.ID,
+		ClientSecret: pi.ClientSecret,
+		Amount:       money.New(pi.Amount, string(pi.Currency)),
+		Status:       toIntentStatus(pi.Status),
+		Metadata:     pi.Metadata,
+	}
+}
 
+func toIntentStatus(s stripe.PaymentIntentStatus) IntentStatus {
+	switch s {
+	case stripe.PaymentIntentStatusRequiresAction:
+		return IntentStatusRequiresAction
+	case stripe.PaymentIntentStatusRequiresCapture:
+		return IntentStatusRequiresCapture
+	case stripe.PaymentIntentStatusProcessing:
+		return IntentStatusProcessing
+	case stripe.PaymentIntentStatusSucceeded:
+		return IntentStatusSucceeded
+	case stripe.PaymentIntentStatusCanceled:
+		return IntentStatusCanceled
+	default:
+		return IntentStatusRequiresPayment
+	}
 }
 
-func NewPaymentClient(stripeSecretKey, successUrl, faliureUrl string) PaymentClient {
+func NewPaymentClient(stripeSecretKey, successUrl, faliureUrl, webhookSecret string) PaymentClient {
 	return &payment{
 		stripeSecretKey: stripeSecretKey,
 		successUrl:      successUrl,
 		faliureUrl:      faliureUrl,
+		webhookSecret:   webhookSecret,
 	}
 }
@@ -0,0 +1,182 @@
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Entry is a suggestion, e.g. a product or category name. Entries are
+// identified by Kind and ID, higher Weight ranks first.
+type Entry struct {
+	Kind   string
+	ID     uint
+	Text   string
+	Weight int64
+}
+
+type key struct {
+	kind string
+	id   uint
+}
+
+type term struct {
+	word  string
+	entry key
+}
+
+// Index answers prefix queries over entry texts in memory. Every word of an
+// entry is indexed, a query matches when each of its words is a prefix of a
+// word of the entry. Writes are cheap and the sorted term list is rebuilt on
+// the next query.
+type Index struct {
+	mu      sync.RWMutex
+	entries map[key]Entry
+	terms   []term
+	dirty   bool
+}
+
+func New() *Index {
+	return &Index{entries: map[key]Entry{}}
+}
+
+// Replace swaps in a complete set of entries.
+func (x *Index) Replace(entries []Entry) {
+
+	fresh := make(map[key]Entry, len(entries))
+	for _, e := range entries {
+		fresh[key{e.Kind, e.ID}] = e
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.entries = fresh
+	x.dirty = true
+}
+
+// Upsert adds or updates an entry. The weight of an existing entry is kept
+// when e.Weight is 0, so editing a product doesn't reset its popularity.
+func (x *Index) Upsert(e Entry) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	k := key{e.Kind, e.ID}
+	if current, ok := x.entries[k]; ok && e.Weight == 0 {
+		e.Weight = current.Weight
+	}
+	x.entries[k] = e
+	x.dirty = true
+}
+
+func (x *Index) Remove(kind string, id uint) {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(x.entries, key{kind, id})
+	x.dirty = true
+}
+
+// Suggest returns up to limit entries matching query, by weight then text.
+func (x *Index) Suggest(query string, limit int) []Entry {
+
+	words := tokenize(query)
+	if len(words) == 0 || limit < 1 {
+		return []Entry{}
+	}
+
+	x.mu.RLock()
+	if x.dirty {
+		x.mu.RUnlock()
+		x.rebuild()
+		x.mu.RLock()
+	}
+	defer x.mu.RUnlock()
+
+	// candidates come from the longest query word, the most selective one
+	longest := words[0]
+	for _, w := range words[1:] {
+		if len(w) > len(longest) {
+			longest = w
+		}
+	}
+
+	seen := map[key]bool{}
+	matches := []Entry{}
+
+	start := sort.Search(len(x.terms), func(i int) bool { return x.terms[i].word >= longest })
+	for i := start; i < len(x.terms) && strings.HasPrefix(x.terms[i].word, longest); i++ {
+
+		k := x.terms[i].entry
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		e, ok := x.entries[k]
+		if ok && matchesAll(tokenize(e.Text), words) {
+			matches = append(matches, e)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Weight != matches[j].Weight {
+			return matches[i].Weight > matches[j].Weight
+		}
+		return matches[i].Text < matches[j].Text
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches
+}
+
+func (x *Index) rebuild() {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	// another query may have rebuilt it while we waited
+	if !x.dirty {
+		return
+	}
+
+	terms := make([]term, 0, len(x.entries))
+	for k, e := range x.entries {
+		for _, w := range tokenize(e.Text) {
+			terms = append(terms, term{word: w, entry: k})
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i].word < terms[j].word })
+
+	x.terms = terms
+	x.dirty = false
+}
+
+// matchesAll reports whether every query word prefixes some entry word.
+func matchesAll(entryWords, queryWords []string) bool {
+	for _, q := range queryWords {
+		found := false
+		for _, w := range entryWords {
+			if strings.HasPrefix(w, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890"
+// in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks Code against the SHA1 test vectors of RFC 6238
+// Appendix B. The RFC lists 8 digit codes, a 6 digit code is their last 6
+// digits.
+func TestCodeRFC6238(t *testing.T) {
+
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+
+		got, err := Code(rfc6238Secret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("T=%d: %v", v.unix, err)
+		}
+
+		want := v.code[len(v.code)-Digits:]
+		if got != want {
+			t.Errorf("T=%d: code = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(rfc6238Secret, Step(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := Validate(rfc6238Secret, code, now); !ok || step != Step(now)-1 {
+		t.Errorf("previous step: Validate = %d, %v, want %d, true", step, ok, Step(now)-1)
+	}
+
+	code, err = Code(rfc6238Secret, Step(now)-2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfc6238Secret, code, now); ok {
+		t.Errorf("code two steps old was accepted")
+	}
+}